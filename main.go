@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"os"
 
 	"github.com/harness-community/drone-read-trusted/plugin"
 	"github.com/kelseyhightower/envconfig"
@@ -11,6 +12,14 @@ import (
 func main() {
 	logrus.SetFormatter(new(formatter))
 
+	// git runs the plugin as its GIT_ASKPASS helper to answer credential prompts.
+	if plugin.IsAskPass(os.Args[1:]) {
+		if err := plugin.AskPass(os.Args[1], os.Stdout); err != nil {
+			logrus.Fatalln(err)
+		}
+		return
+	}
+
 	var args plugin.Args
 	if err := envconfig.Process("", &args); err != nil {
 		logrus.Fatalln(err)
@@ -25,5 +34,5 @@ func main() {
 type formatter struct{}
 
 func (*formatter) Format(entry *logrus.Entry) ([]byte, error) {
-	return []byte(entry.Message + "\n"), nil
+	return []byte(plugin.Redact(entry.Message) + "\n"), nil
 }
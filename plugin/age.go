@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ageIdentityFile is the path of the identity file written from the
+// age_identity setting, passed to age with -i.
+var ageIdentityFile string
+
+// configureAgeIdentity writes the age identity to a private temporary file.
+// The returned function removes it again.
+func configureAgeIdentity(identity string) (func(), error) {
+	tmp, err := os.CreateTemp("", "trusted-age-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	if _, err := tmp.WriteString(strings.TrimSpace(identity) + "\n"); err != nil {
+		tmp.Close()
+		cleanup()
+		return nil, fmt.Errorf("failed to write age identity: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to write age identity: %w", err)
+	}
+	ageIdentityFile = tmp.Name()
+	return cleanup, nil
+}
+
+// decryptAge decrypts an age encrypted file, binary or ASCII armored, with
+// the age binary and the configured identity.
+func decryptAge(filePath, content string) (string, error) {
+	if ageIdentityFile == "" {
+		return "", fmt.Errorf("failed to decrypt %s: age_identity is not set", filePath)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := command("age", "--decrypt", "-i", ageIdentityFile)
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to decrypt %s with age: %w", filePath, commandError(err, stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// compareAge decrypts both sides and compares the plaintext. age encryption
+// is randomized, so the ciphertexts differ even when the plaintext is equal.
+func compareAge(filePath string, opts compareOptions, trusted, current string) (bool, error) {
+	trustedPlain, err := decryptAge(filePath, trusted)
+	if err != nil {
+		return false, fmt.Errorf("trusted version: %w", err)
+	}
+	currentPlain, err := decryptAge(filePath, current)
+	if err != nil {
+		return false, fmt.Errorf("current version: %w", err)
+	}
+	return compareContent(filePath, opts, trustedPlain, currentPlain)
+}
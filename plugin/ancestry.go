@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// checkAncestry verifies that the tip of the trusted ref is an ancestor of
+// HEAD, so branches based on stale trusted content are rejected even when the
+// files happen to match.
+func checkAncestry(repoPath, ref string) error {
+	if err := fetchRef(repoPath, ref); err != nil {
+		return err
+	}
+	tip, err := revParse(repoPath, fetchedRef(ref)+"^{commit}")
+	if err != nil {
+		return err
+	}
+
+	err = command("git", "-C", repoPath, "merge-base", "--is-ancestor", tip, "HEAD").Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		if isShallowRepository(repoPath) {
+			return fmt.Errorf("the tip %s of %s is not an ancestor of the current commit, or the shallow clone is too short to tell", tip, describeTrustedRef(ref))
+		}
+		return fmt.Errorf("the tip %s of %s is not an ancestor of the current commit: update the branch with the trusted changes", tip, describeTrustedRef(ref))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check the ancestry of %s: %w", tip, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// approvalOverride lets a file that differs from its trusted version pass
+// when every commit that changed it on the current branch is in a GitHub pull
+// request approved by enough members of a team.
+type approvalOverride struct {
+	org      string
+	team     string
+	required int
+	token    string
+}
+
+// newApprovalOverride parses the team as org/team.
+func newApprovalOverride(team string, required int, token string) (*approvalOverride, error) {
+	org, name, ok := strings.Cut(strings.TrimPrefix(team, "@"), "/")
+	if !ok || org == "" || name == "" {
+		return nil, fmt.Errorf("approval_team must be an org/team slug, got %q", team)
+	}
+	if required < 1 {
+		return nil, fmt.Errorf("min_approvals must be positive")
+	}
+	return &approvalOverride{org: org, team: name, required: required, token: token}, nil
+}
+
+// apply passes the mismatched results whose changes were approved.
+func (a *approvalOverride) apply(plan *verificationPlan, results []fileResult) error {
+	for i := range results {
+		result := &results[i]
+		if result.Matched || result.Reason != reasonContentDiffers {
+			continue
+		}
+		pull, err := a.approvedPull(plan.repoPath, plan.refFor(result.Path), result.Path)
+		if err != nil {
+			return err
+		}
+		if pull == 0 {
+			continue
+		}
+		logrus.Infof("File %s differs from %s, but the change was approved in pull request #%d",
+			result.Path, describeTrustedRef(plan.refFor(result.Path)), pull)
+		result.Matched = true
+		result.Reason = ""
+		result.ApprovedPullRequest = pull
+	}
+	return nil
+}
+
+// approvedPull returns the pull request that approved the changes to a file,
+// or 0 when they were not approved. Uncommitted changes are never approved.
+func (a *approvalOverride) approvedPull(repoPath, ref, filePath string) (int, error) {
+	if err := command("git", "-C", repoPath, "diff", "--quiet", "HEAD", "--", filePath).Run(); err != nil {
+		logrus.Warnf("File %s has uncommitted changes, which cannot have been approved", filePath)
+		return 0, nil
+	}
+	commits, err := commitsSinceTrusted(repoPath, ref, filePath)
+	if err != nil {
+		return 0, err
+	}
+	if len(commits) == 0 {
+		return 0, nil
+	}
+	repo, err := githubRepo()
+	if err != nil {
+		return 0, err
+	}
+	// Approvals count when they were given for the commit being built.
+	head, err := revParse(repoPath, "HEAD")
+	if err != nil {
+		return 0, err
+	}
+
+	approvedPull := 0
+	for _, commit := range commits {
+		pulls, err := githubCommitPulls(repo, commit, a.token)
+		if err != nil {
+			return 0, err
+		}
+		found := 0
+		for _, pull := range pulls {
+			approved, err := a.teamApprovals(repo, pull, head)
+			if err != nil {
+				return 0, err
+			}
+			if approved >= a.required {
+				found = pull
+				break
+			}
+			logrus.Infof("Pull request #%d has %d of %d required approvals from %s/%s", pull, approved, a.required, a.org, a.team)
+		}
+		if found == 0 {
+			logrus.Warnf("Commit %s changing %s is not in a pull request approved by %s/%s", commit, filePath, a.org, a.team)
+			return 0, nil
+		}
+		// Commits are listed newest first, so this is the most recent pull request.
+		if approvedPull == 0 {
+			approvedPull = found
+		}
+	}
+	return approvedPull, nil
+}
+
+// teamApprovals counts the current approvals of a pull request by team
+// members.
+func (a *approvalOverride) teamApprovals(repo string, pull int, head string) (int, error) {
+	approvers, err := githubApprovals(repo, pull, head, a.token)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, login := range approvers {
+		member, err := githubTeamMember(a.org, a.team, login, a.token)
+		if err != nil {
+			return 0, err
+		}
+		if member {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// commitsSinceTrusted lists the commits on HEAD, newest first, that touch a
+// file and are not on the trusted ref.
+func commitsSinceTrusted(repoPath, ref, filePath string) ([]string, error) {
+	trusted, err := trustedCommit(repoPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	output, err := command("git", "-C", repoPath, "log", "--format=%H", trusted+"..HEAD", "--", filePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits touching %s: %w", filePath, err)
+	}
+	return strings.Fields(string(output)), nil
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType   = "application/vnd.in-toto+json"
+	// verificationPredicateType identifies the predicate written by this plugin.
+	verificationPredicateType = "https://github.com/harness-community/drone-read-trusted/verification/v1"
+)
+
+// inTotoStatement is an in-toto v1 statement about the verified files.
+type inTotoStatement struct {
+	Type          string                `json:"_type"`
+	Subject       []inTotoSubject       `json:"subject"`
+	PredicateType string                `json:"predicateType"`
+	Predicate     verificationPredicate `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// verificationPredicate records what the files were verified against and when.
+type verificationPredicate struct {
+	TrustedRefs   []attestedRef `json:"trustedRefs"`
+	CurrentBranch string        `json:"currentBranch"`
+	Repository    string        `json:"repository,omitempty"`
+	BuildURL      string        `json:"buildUrl,omitempty"`
+	VerifiedAt    string        `json:"verifiedAt"`
+}
+
+type attestedRef struct {
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+}
+
+// dsseEnvelope is a DSSE envelope wrapping the signed statement.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// attestation signs in-toto statements with a private key.
+type attestation struct {
+	signer crypto.Signer
+	keyID  string
+	// path is where the envelope is written, empty to only export it.
+	path string
+}
+
+// newAttestation parses a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key.
+// The key ID is the SHA-256 digest of the DER encoded public key.
+func newAttestation(key, path string) (*attestation, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("attestation_key is not a PEM encoded private key")
+	}
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse attestation_key: %w", err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported attestation_key type %T", parsed)
+	}
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the attestation public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return &attestation{signer: signer, keyID: hex.EncodeToString(sum[:]), path: path}, nil
+}
+
+// write signs a statement about the verified files and exports the envelope as
+// TRUSTED_ATTESTATION, also writing it to the configured path.
+func (a *attestation) write(repoPath string, results []fileResult, refs, commits []string, currentBranch string) error {
+	statement := inTotoStatement{
+		Type:          inTotoStatementType,
+		PredicateType: verificationPredicateType,
+		Predicate: verificationPredicate{
+			CurrentBranch: currentBranch,
+			Repository:    os.Getenv("DRONE_REPO"),
+			BuildURL:      os.Getenv("DRONE_BUILD_LINK"),
+			VerifiedAt:    time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	for i, ref := range refs {
+		statement.Predicate.TrustedRefs = append(statement.Predicate.TrustedRefs, attestedRef{Ref: ref, Commit: commits[i]})
+	}
+	for _, result := range results {
+		// Only content and sha256 modes record the digest while verifying.
+		digest := result.SHA256
+		if digest == "" {
+			fullPath, err := resolveInRepo(repoPath, result.Path)
+			if err != nil {
+				return err
+			}
+			if digest, err = sha256File(fullPath); err != nil {
+				return fmt.Errorf("failed to hash %s: %w", result.Path, err)
+			}
+		}
+		statement.Subject = append(statement.Subject, inTotoSubject{
+			Name:   result.Path,
+			Digest: map[string]string{"sha256": digest},
+		})
+	}
+
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return fmt.Errorf("failed to encode attestation: %w", err)
+	}
+	sig, err := a.sign(payload)
+	if err != nil {
+		return err
+	}
+	envelope, err := json.Marshal(dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{{KeyID: a.keyID, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode attestation envelope: %w", err)
+	}
+
+	if a.path != "" {
+		path := a.path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(repoPath, path)
+		}
+		if err := os.WriteFile(path, envelope, 0644); err != nil {
+			return fmt.Errorf("failed to write attestation to %s: %w", path, err)
+		}
+		logrus.Infof("Wrote verification attestation to %s", path)
+	}
+	if err := WriteEnvToFile("TRUSTED_ATTESTATION", base64.StdEncoding.EncodeToString(envelope)); err != nil {
+		return fmt.Errorf("failed to write TRUSTED_ATTESTATION: %w", err)
+	}
+	return nil
+}
+
+// sign signs the DSSE pre-authentication encoding of the payload.
+func (a *attestation) sign(payload []byte) ([]byte, error) {
+	pae := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(inTotoPayloadType), inTotoPayloadType, len(payload), payload))
+
+	var sig []byte
+	var err error
+	switch key := a.signer.(type) {
+	case ed25519.PrivateKey:
+		sig, err = key.Sign(rand.Reader, pae, crypto.Hash(0))
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		digest := sha256.Sum256(pae)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("unsupported attestation_key type %T", key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign attestation: %w", err)
+	}
+	return sig, nil
+}
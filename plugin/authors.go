@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// authorPolicy requires the last commit touching a file on the trusted ref to
+// be authored or committed by an approved identity. Entries containing "@"
+// are emails; the others are GitHub logins, looked up through the API.
+type authorPolicy struct {
+	emails map[string]bool
+	logins map[string]bool
+	token  string
+}
+
+func newAuthorPolicy(allowlist []string, token string) authorPolicy {
+	p := authorPolicy{emails: make(map[string]bool), logins: make(map[string]bool), token: token}
+	for _, entry := range allowlist {
+		if strings.Contains(entry, "@") {
+			p.emails[strings.ToLower(entry)] = true
+		} else {
+			p.logins[strings.ToLower(strings.TrimPrefix(entry, "@"))] = true
+		}
+	}
+	return p
+}
+
+func (p authorPolicy) check(repoPath, ref, filePath string) (string, error) {
+	resolved, err := resolveTrustedRef(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	output, err := command("git", "-C", repoPath, "log", "-1", "--format=%H%x00%ae%x00%ce", resolved, "--", filePath).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the last commit of %s on %s: %w", filePath, resolved, err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), "\x00")
+	if len(fields) != 3 {
+		return "", fmt.Errorf("failed to read the last commit of %s on %s", filePath, resolved)
+	}
+	commit, authorEmail, committerEmail := fields[0], strings.ToLower(fields[1]), strings.ToLower(fields[2])
+	if p.emails[authorEmail] || p.emails[committerEmail] {
+		return "", nil
+	}
+
+	if len(p.logins) > 0 {
+		authorLogin, committerLogin, err := githubCommitLogins(commit, p.token)
+		if err != nil {
+			return "", err
+		}
+		if p.logins[strings.ToLower(authorLogin)] || p.logins[strings.ToLower(committerLogin)] {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("last trusted commit %s by %s is not from an approved author", commit, authorEmail), nil
+}
+
+// githubCommitLogins returns the GitHub logins of a commit's author and
+// committer, for the repository named by DRONE_REPO.
+func githubCommitLogins(commit, token string) (string, string, error) {
+	repo, err := githubRepo()
+	if err != nil {
+		return "", "", err
+	}
+	var body struct {
+		Author    *struct{ Login string } `json:"author"`
+		Committer *struct{ Login string } `json:"committer"`
+	}
+	if _, err := githubGet(fmt.Sprintf("/repos/%s/commits/%s", repo, commit), token, &body); err != nil {
+		return "", "", fmt.Errorf("failed to look up commit %s on GitHub: %w", commit, err)
+	}
+	var author, committer string
+	if body.Author != nil {
+		author = body.Author.Login
+	}
+	if body.Committer != nil {
+		committer = body.Committer.Login
+	}
+	return author, committer, nil
+}
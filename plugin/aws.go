@@ -0,0 +1,351 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials are the static or temporary credentials requests are
+// signed with.
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// loadAWSCredentials returns the credentials of the runner, from the first
+// of the standard sources that has them: the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables, a web identity token file
+// (EKS IRSA), the ECS container credentials endpoint, or the EC2 instance
+// profile. Credentials not taken from the environment are cached.
+func loadAWSCredentials() (awsCredentials, error) {
+	creds := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID != "" && creds.secretAccessKey != "" {
+		return creds, nil
+	}
+
+	awsCredsMu.Lock()
+	defer awsCredsMu.Unlock()
+	if awsCredsLoaded {
+		return awsCredsCached, awsCredsErr
+	}
+	awsCredsLoaded = true
+	switch {
+	case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+		awsCredsCached, awsCredsErr = webIdentityCredentials()
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		awsCredsCached, awsCredsErr = containerCredentials()
+	case os.Getenv("AWS_EC2_METADATA_DISABLED") != "true":
+		awsCredsCached, awsCredsErr = instanceCredentials()
+	default:
+		awsCredsErr = fmt.Errorf("no AWS credentials found")
+	}
+	registerSecret(awsCredsCached.secretAccessKey, awsCredsCached.sessionToken)
+	if awsCredsErr != nil {
+		awsCredsErr = fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set and the runner has no AWS role: %w", awsCredsErr)
+	}
+	return awsCredsCached, awsCredsErr
+}
+
+var (
+	awsCredsMu     sync.Mutex
+	awsCredsLoaded bool
+	awsCredsCached awsCredentials
+	awsCredsErr    error
+)
+
+// awsMetadataClient talks to the link-local credential endpoints, which
+// answer quickly or not at all.
+var awsMetadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// webIdentityCredentials assumes AWS_ROLE_ARN with the token in
+// AWS_WEB_IDENTITY_TOKEN_FILE, as set up by EKS for service accounts.
+func webIdentityCredentials() (awsCredentials, error) {
+	token, err := os.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to read the web identity token: %w", err)
+	}
+	creds, _, err := assumeRoleWithWebIdentity(os.Getenv("AWS_ROLE_ARN"), strings.TrimSpace(string(token)))
+	return creds, err
+}
+
+// assumeRoleWithWebIdentity exchanges an OIDC token for temporary
+// credentials of a role. The call is authenticated by the token and not
+// signed.
+func assumeRoleWithWebIdentity(roleARN, token string) (awsCredentials, time.Time, error) {
+	endpoint := "https://sts.amazonaws.com/"
+	if region := awsRegion(); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "drone-read-trusted"
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {token},
+	}
+	resp, err := http.PostForm(endpoint, query)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("AssumeRoleWithWebIdentity: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("AssumeRoleWithWebIdentity: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The error response explains why the token was rejected.
+		return awsCredentials{}, time.Time{}, fmt.Errorf("AssumeRoleWithWebIdentity: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &out); err != nil || out.Credentials.AccessKeyID == "" {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("AssumeRoleWithWebIdentity: unexpected response")
+	}
+	creds := awsCredentials{
+		accessKeyID:     out.Credentials.AccessKeyID,
+		secretAccessKey: out.Credentials.SecretAccessKey,
+		sessionToken:    out.Credentials.SessionToken,
+	}
+	return creds, out.Credentials.Expiration, nil
+}
+
+// awsRoleCredentials is the response of the container and instance
+// credential endpoints.
+type awsRoleCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+func (c awsRoleCredentials) credentials() (awsCredentials, error) {
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("the credentials endpoint returned no credentials")
+	}
+	return awsCredentials{accessKeyID: c.AccessKeyID, secretAccessKey: c.SecretAccessKey, sessionToken: c.Token}, nil
+}
+
+// containerCredentials reads the credentials of the ECS task role.
+func containerCredentials() (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		token, err := os.ReadFile(file)
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("failed to read the container authorization token: %w", err)
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	var out awsRoleCredentials
+	if err := awsMetadataGet(req, &out); err != nil {
+		return awsCredentials{}, err
+	}
+	return out.credentials()
+}
+
+// instanceCredentials reads the credentials of the EC2 instance profile
+// through IMDSv2.
+func instanceCredentials() (awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequest(http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance metadata: %w", err)
+	}
+	token, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("instance metadata: failed to get a session token")
+	}
+
+	get := func(path string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, imds+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return req
+	}
+	resp, err = awsMetadataClient.Do(get("/meta-data/iam/security-credentials/"))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance metadata: %w", err)
+	}
+	role, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("instance metadata: the instance has no role")
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+	var out awsRoleCredentials
+	if err := awsMetadataGet(get("/meta-data/iam/security-credentials/"+name), &out); err != nil {
+		return awsCredentials{}, err
+	}
+	return out.credentials()
+}
+
+// awsMetadataGet sends a request to a credential endpoint and decodes the
+// JSON response into out.
+func awsMetadataGet(req *http.Request, out interface{}) error {
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("credentials endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("credentials endpoint: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the credentials: %w", err)
+	}
+	return nil
+}
+
+// awsRegion returns the region from AWS_REGION or AWS_DEFAULT_REGION.
+func awsRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// awsError is an error response of an AWS JSON API.
+type awsError struct {
+	Status  int
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// is reports whether the error has the given exception type. Types may be
+// prefixed with a namespace, e.g. com.amazonaws.codecommit#FileDoesNotExistException.
+func (e *awsError) is(exception string) bool {
+	return e.Type == exception || strings.HasSuffix(e.Type, "#"+exception)
+}
+
+// awsCall calls an operation of an AWS JSON 1.1 API, such as CodeCommit or
+// Secrets Manager, and decodes the response into out.
+func awsCall(creds awsCredentials, service, region, endpoint, target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signAWSRequest(req, body, creds, service, region, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &awsError{Status: resp.StatusCode}
+		if json.Unmarshal(respBody, apiErr) != nil || apiErr.Type == "" {
+			apiErr.Type = resp.Status
+		}
+		return apiErr
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode the %s response: %w", target, err)
+	}
+	return nil
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4.
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, service, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	// The host header is not in req.Header, so add it explicitly.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, sha256Hex(string(body)),
+	}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// readAWSSecret resolves git_pat_from, the ARN of a Secrets Manager secret or
+// an SSM parameter holding the git token, with the runner's AWS credentials.
+// A secret storing JSON selects a key with a #key suffix, e.g.
+// arn:aws:secretsmanager:us-east-1:123456789012:secret:ci/github-AbCdEf#token.
+func readAWSSecret(ref string) (string, error) {
+	arn, key, _ := strings.Cut(ref, "#")
+	// arn:partition:service:region:account:resource
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[3] == "" {
+		return "", fmt.Errorf("git_pat_from must be a Secrets Manager secret or SSM parameter ARN")
+	}
+	partition, service, region := parts[1], parts[2], parts[3]
+	domain := "amazonaws.com"
+	if partition == "aws-cn" {
+		domain = "amazonaws.com.cn"
+	}
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	switch {
+	case service == "secretsmanager" && strings.HasPrefix(parts[5], "secret:"):
+		var out struct {
+			SecretString string `json:"SecretString"`
+		}
+		endpoint := fmt.Sprintf("https://secretsmanager.%s.%s/", region, domain)
+		in := map[string]string{"SecretId": arn}
+		if err := awsCall(creds, "secretsmanager", region, endpoint, "secretsmanager.GetSecretValue", in, &out); err != nil {
+			return "", fmt.Errorf("failed to read the git token from Secrets Manager: %w", err)
+		}
+		value = out.SecretString
+	case service == "ssm" && strings.HasPrefix(parts[5], "parameter/"):
+		var out struct {
+			Parameter struct {
+				Value string `json:"Value"`
+			} `json:"Parameter"`
+		}
+		endpoint := fmt.Sprintf("https://ssm.%s.%s/", region, domain)
+		in := map[string]interface{}{"Name": arn, "WithDecryption": true}
+		if err := awsCall(creds, "ssm", region, endpoint, "AmazonSSM.GetParameter", in, &out); err != nil {
+			return "", fmt.Errorf("failed to read the git token from SSM Parameter Store: %w", err)
+		}
+		value = out.Parameter.Value
+	default:
+		return "", fmt.Errorf("git_pat_from must be a Secrets Manager secret or SSM parameter ARN")
+	}
+
+	if key != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return "", fmt.Errorf("the secret in git_pat_from is not a JSON object, so it has no key %s", key)
+		}
+		field, ok := fields[key].(string)
+		if !ok {
+			return "", fmt.Errorf("the secret in git_pat_from has no key %s", key)
+		}
+		value = field
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("the secret in git_pat_from is empty")
+	}
+	logrus.Infof("Using the git token from %s", arn)
+	return value, nil
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// azureAPIVersion is the Azure DevOps REST API version used.
+const azureAPIVersion = "7.0"
+
+// azureRepo identifies a repository in Azure Repos.
+type azureRepo struct {
+	org     string
+	project string
+	name    string
+}
+
+// isAzureHost reports whether a host serves Azure Repos over HTTPS.
+func isAzureHost(host string) bool {
+	return host == "dev.azure.com" || strings.HasSuffix(host, ".visualstudio.com")
+}
+
+// parseAzureRemote extracts the organization, project and repository from
+// an Azure Repos remote: https://dev.azure.com/org/project/_git/repo,
+// https://org.visualstudio.com/project/_git/repo or
+// git@ssh.dev.azure.com:v3/org/project/repo.
+func parseAzureRemote(remote originRemote) (azureRepo, error) {
+	segments := strings.Split(remote.Path, "/")
+	switch {
+	case remote.Host == "ssh.dev.azure.com" && len(segments) == 4 && segments[0] == "v3":
+		return azureRepo{org: segments[1], project: segments[2], name: segments[3]}, nil
+	case remote.Host == "dev.azure.com" && len(segments) == 4 && segments[2] == "_git":
+		return azureRepo{org: segments[0], project: segments[1], name: segments[3]}, nil
+	case strings.HasSuffix(remote.Host, ".visualstudio.com"):
+		if len(segments) == 4 && segments[0] == "DefaultCollection" {
+			segments = segments[1:]
+		}
+		if len(segments) == 3 && segments[1] == "_git" {
+			org := strings.TrimSuffix(remote.Host, ".visualstudio.com")
+			return azureRepo{org: org, project: segments[0], name: segments[2]}, nil
+		}
+	}
+	return azureRepo{}, fmt.Errorf("origin is not an Azure Repos remote")
+}
+
+// azureItems reads trusted files through the Azure DevOps Items API.
+type azureItems struct {
+	repo     azureRepo
+	username string
+	token    string
+}
+
+// baseURL returns the API root of the repository.
+func (a azureItems) baseURL() string {
+	return fmt.Sprintf("https://dev.azure.com/%s/%s/_apis/git/repositories/%s",
+		url.PathEscape(a.repo.org), url.PathEscape(a.repo.project), url.PathEscape(a.repo.name))
+}
+
+func (a azureItems) commit(ref string) (string, error) {
+	versionType := "branch"
+	if tag, ok := strings.CutPrefix(ref, tagRefPrefix); ok {
+		ref, versionType = tag, "tag"
+	}
+	query := url.Values{
+		"searchCriteria.itemVersion.version":     {ref},
+		"searchCriteria.itemVersion.versionType": {versionType},
+		"$top":                                   {"1"},
+		"api-version":                            {azureAPIVersion},
+	}
+	body, _, err := a.get("/commits?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	var commits struct {
+		Value []struct {
+			CommitID string `json:"commitId"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(body, &commits); err != nil {
+		return "", fmt.Errorf("failed to decode the commits of %s: %w", ref, err)
+	}
+	if len(commits.Value) == 0 {
+		return "", fmt.Errorf("no commit found for %s", ref)
+	}
+	return commits.Value[0].CommitID, nil
+}
+
+func (a azureItems) readFile(commit, filePath string) (string, bool, error) {
+	query := url.Values{
+		"path":                          {"/" + filePath},
+		"versionDescriptor.version":     {commit},
+		"versionDescriptor.versionType": {"commit"},
+		"download":                      {"true"},
+		"api-version":                   {azureAPIVersion},
+	}
+	body, status, err := a.get("/items?" + query.Encode())
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(body), true, nil
+}
+
+// get fetches an API path, authenticating the PAT with basic auth, and
+// returns the response body and status code.
+func (a azureItems) get(path string) ([]byte, int, error) {
+	req, err := http.NewRequest(http.MethodGet, a.baseURL()+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a.token != "" {
+		req.SetBasicAuth(a.username, a.token)
+	}
+	// Errors name the endpoint without the query.
+	endpoint, _, _ := strings.Cut(path, "?")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("GET %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read %s: %w", endpoint, err)
+	}
+	return body, resp.StatusCode, nil
+}
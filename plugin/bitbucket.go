@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// bitbucketAPI is the base URL of the Bitbucket Cloud REST API.
+const bitbucketAPI = "https://api.bitbucket.org/2.0"
+
+// bitbucketSrc reads trusted files through the Bitbucket Cloud src API.
+type bitbucketSrc struct {
+	// repo is the workspace/slug of the repository.
+	repo string
+	// username authenticates an app password with basic auth. Without it,
+	// token is sent as an OAuth bearer token.
+	username string
+	token    string
+}
+
+func (b bitbucketSrc) commit(ref string) (string, error) {
+	body, _, err := b.get(fmt.Sprintf("/repositories/%s/commit/%s", b.repo, url.PathEscape(ref)))
+	if err != nil {
+		return "", err
+	}
+	var commit struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(body, &commit); err != nil {
+		return "", fmt.Errorf("failed to decode commit %s: %w", ref, err)
+	}
+	return commit.Hash, nil
+}
+
+func (b bitbucketSrc) readFile(commit, filePath string) (string, bool, error) {
+	body, status, err := b.get(fmt.Sprintf("/repositories/%s/src/%s/%s", b.repo, commit, escapePath(filePath)))
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(body), true, nil
+}
+
+// get fetches an API path and returns the response body and status code.
+func (b bitbucketSrc) get(path string) ([]byte, int, error) {
+	req, err := http.NewRequest(http.MethodGet, bitbucketAPI+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case b.token == "":
+	case b.username != "":
+		req.SetBasicAuth(b.username, b.token)
+	default:
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("GET %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return body, resp.StatusCode, nil
+}
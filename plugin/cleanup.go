@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	cleanupMu sync.Mutex
+	// cleanups remove the credentials and temporary files written for a run,
+	// in reverse order of registration.
+	cleanups []func()
+)
+
+// registerCleanup adds a function that runs when Exec returns or the plugin
+// is interrupted.
+func registerCleanup(cleanup func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanups = append(cleanups, cleanup)
+}
+
+// runCleanups runs the registered cleanups once.
+func runCleanups() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
+}
+
+// cleanupOnSignal kills the running subprocesses with cancel, runs the
+// cleanups and exits when the plugin is interrupted or terminated, e.g. when
+// the build is canceled, since deferred functions do not run then. The
+// returned function stops watching for signals.
+func cleanupOnSignal(cancel context.CancelFunc) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logrus.Warnf("Received %s, removing credentials", sig)
+			cancel()
+			runCleanups()
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// exitOnTimeout stops the run when it exceeds timeout, even where it waits on
+// something the context does not reach: stop closes the outputs and kills the
+// subprocesses, then the cleanups run and the plugin exits before the runner
+// kills the step. stop reports false when the run already wrote its result,
+// which then stands. The returned function disarms it.
+func exitOnTimeout(timeout time.Duration, stop func() bool) func() {
+	timer := time.AfterFunc(timeout, func() {
+		if !stop() {
+			return
+		}
+		logrus.Errorf("The plugin did not finish within %s, stopping", timeout)
+		runCleanups()
+		os.Exit(1)
+	})
+	return func() { timer.Stop() }
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// codecommitHTTPS matches the HTTPS and SSH remotes of a CodeCommit
+// repository, e.g. https://git-codecommit.us-east-1.amazonaws.com/v1/repos/name.
+var codecommitHTTPS = regexp.MustCompile(`^(?:https|ssh)://(?:[^@/]+@)?git-codecommit(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?(?::\d+)?/v1/repos/([^/]+?)/?$`)
+
+// codecommitRepo identifies a CodeCommit repository.
+type codecommitRepo struct {
+	region string
+	name   string
+}
+
+// parseCodeCommitRemote parses a CodeCommit remote, either an HTTPS or SSH
+// URL or a git-remote-codecommit URL such as codecommit::us-east-1://name or
+// codecommit://profile@name. Without a region in the URL, AWS_REGION is used.
+func parseCodeCommitRemote(raw string) (codecommitRepo, error) {
+	if m := codecommitHTTPS.FindStringSubmatch(raw); m != nil {
+		return codecommitRepo{region: m[1], name: m[2]}, nil
+	}
+	if rest, ok := strings.CutPrefix(raw, "codecommit:"); ok {
+		// codecommit::region://name carries the region, codecommit://name does not.
+		region := awsRegion()
+		name := strings.TrimPrefix(rest, "//")
+		if r, after, ok := strings.Cut(rest, "://"); ok && strings.HasPrefix(r, ":") {
+			region, name = strings.TrimPrefix(r, ":"), after
+		}
+		if i := strings.LastIndex(name, "@"); i >= 0 {
+			name = name[i+1:]
+		}
+		if name == "" || region == "" {
+			return codecommitRepo{}, fmt.Errorf("cannot determine the CodeCommit repository and region from origin")
+		}
+		return codecommitRepo{region: region, name: name}, nil
+	}
+	return codecommitRepo{}, fmt.Errorf("origin is not a CodeCommit remote")
+}
+
+// codecommitFiles reads trusted files through the CodeCommit API, signing
+// requests with the AWS credentials from the environment.
+type codecommitFiles struct {
+	repo  codecommitRepo
+	creds awsCredentials
+}
+
+// call invokes a CodeCommit operation.
+func (c codecommitFiles) call(operation string, in, out interface{}) error {
+	endpoint := fmt.Sprintf("https://codecommit.%s.amazonaws.com/", c.repo.region)
+	return awsCall(c.creds, "codecommit", c.repo.region, endpoint, "CodeCommit_20150413."+operation, in, out)
+}
+
+// commit resolves a branch or tag through GetFolder, which accepts any
+// commit specifier and reports the commit it resolved to.
+func (c codecommitFiles) commit(ref string) (string, error) {
+	var folder struct {
+		CommitID string `json:"commitId"`
+	}
+	in := map[string]string{"repositoryName": c.repo.name, "commitSpecifier": ref, "folderPath": "/"}
+	if err := c.call("GetFolder", in, &folder); err != nil {
+		return "", err
+	}
+	return folder.CommitID, nil
+}
+
+func (c codecommitFiles) readFile(commit, filePath string) (string, bool, error) {
+	var file struct {
+		FileContent string `json:"fileContent"`
+	}
+	in := map[string]string{"repositoryName": c.repo.name, "commitSpecifier": commit, "filePath": filePath}
+	err := c.call("GetFile", in, &file)
+	var apiErr *awsError
+	if errors.As(err, &apiErr) && apiErr.is("FileDoesNotExistException") {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	content, err := base64.StdEncoding.DecodeString(file.FileContent)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	return string(content), true, nil
+}
@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// codeownersPaths are the locations GitHub reads CODEOWNERS from, in order.
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeownersRule assigns owners to the paths matching a pattern.
+type codeownersRule struct {
+	pattern string
+	owners  []string
+}
+
+// parseCodeowners parses a CODEOWNERS file. Later rules take precedence.
+func parseCodeowners(content string) []codeownersRule {
+	var rules []codeownersRule
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rules = append(rules, codeownersRule{pattern: fields[0], owners: fields[1:]})
+	}
+	return rules
+}
+
+// codeownersFor returns the owners of filePath according to the last
+// matching rule. A matching rule without owners leaves the path unowned.
+func codeownersFor(rules []codeownersRule, filePath string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if codeownersMatch(rules[i].pattern, filePath) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// codeownersMatch matches a path against a CODEOWNERS pattern, which follows
+// gitignore rules: a leading slash anchors the pattern to the root, a pattern
+// without a slash matches at any depth, and a directory owns everything below it.
+func codeownersMatch(pattern, filePath string) bool {
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+	if !anchored {
+		pattern = "**/" + pattern
+	}
+	return matchGlob(pattern, filePath) || matchGlob(pattern+"/**", filePath)
+}
+
+// codeownersPolicy requires every commit on the current branch that touches
+// a file to have been approved, in a GitHub pull request review, by one of
+// the file's owners listed in CODEOWNERS on the trusted ref.
+type codeownersPolicy struct {
+	rules []codeownersRule
+	token string
+
+	mu sync.Mutex
+	// approvers caches the approving reviewers of each commit's pull requests.
+	approvers map[string][]string
+	// members caches team membership lookups keyed by "org/team:login".
+	members map[string]bool
+}
+
+// newCodeownersPolicy reads CODEOWNERS from the trusted ref.
+func newCodeownersPolicy(repoPath, ref, token string) (*codeownersPolicy, error) {
+	for _, p := range codeownersPaths {
+		content, found, err := readOptionalTrustedFile(repoPath, ref, p)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			logrus.Infof("Using %s from %s", p, describeTrustedRef(ref))
+			return &codeownersPolicy{
+				rules:     parseCodeowners(content),
+				token:     token,
+				approvers: make(map[string][]string),
+				members:   make(map[string]bool),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no CODEOWNERS file on %s", describeTrustedRef(ref))
+}
+
+func (p *codeownersPolicy) check(repoPath, ref, filePath string) (string, error) {
+	owners := codeownersFor(p.rules, filePath)
+	if len(owners) == 0 {
+		return "no code owners for this path in CODEOWNERS", nil
+	}
+
+	commits, err := commitsSinceTrusted(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	// Approvals count when they were given for the commit being built.
+	head, err := revParse(repoPath, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for _, commit := range commits {
+		approved, err := p.approvedByOwner(commit, head, owners)
+		if err != nil {
+			return "", err
+		}
+		if !approved {
+			return fmt.Sprintf("commit %s was not approved by a code owner (%s)", commit, strings.Join(owners, " ")), nil
+		}
+	}
+	return "", nil
+}
+
+// approvedByOwner reports whether any pull request containing the commit was
+// approved by one of the owners.
+func (p *codeownersPolicy) approvedByOwner(commit, head string, owners []string) (bool, error) {
+	approvers, err := p.commitApprovers(commit, head)
+	if err != nil {
+		return false, err
+	}
+	for _, approver := range approvers {
+		for _, owner := range owners {
+			ok, err := p.isOwner(owner, approver)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// commitApprovers returns the logins that approved a pull request containing
+// the commit, at its head or at head, the commit being built.
+func (p *codeownersPolicy) commitApprovers(commit, head string) ([]string, error) {
+	p.mu.Lock()
+	cached, ok := p.approvers[commit]
+	p.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	repo, err := githubRepo()
+	if err != nil {
+		return nil, err
+	}
+	pulls, err := githubCommitPulls(repo, commit, p.token)
+	if err != nil {
+		return nil, err
+	}
+	var approvers []string
+	for _, pull := range pulls {
+		logins, err := githubApprovals(repo, pull, head, p.token)
+		if err != nil {
+			return nil, err
+		}
+		approvers = appendUnique(approvers, logins...)
+	}
+
+	p.mu.Lock()
+	p.approvers[commit] = approvers
+	p.mu.Unlock()
+	return approvers, nil
+}
+
+// isOwner reports whether a login matches a CODEOWNERS owner, which is a
+// @user, an @org/team or an email address. Emails cannot be matched to
+// reviewers through the API and never match.
+func (p *codeownersPolicy) isOwner(owner, login string) (bool, error) {
+	if !strings.HasPrefix(owner, "@") {
+		return false, nil
+	}
+	owner = strings.TrimPrefix(owner, "@")
+	org, team, isTeam := strings.Cut(owner, "/")
+	if !isTeam {
+		return strings.EqualFold(owner, login), nil
+	}
+
+	key := owner + ":" + strings.ToLower(login)
+	p.mu.Lock()
+	member, ok := p.members[key]
+	p.mu.Unlock()
+	if ok {
+		return member, nil
+	}
+	member, err := githubTeamMember(org, team, login, p.token)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	p.members[key] = member
+	p.mu.Unlock()
+	return member, nil
+}
@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandCtx is the context of the running Exec. Canceling it kills the
+// subprocesses the plugin started, so a hung git command cannot outlive the
+// run.
+var commandCtx = context.Background()
+
+// gitTimeout limits every git command when set, so a stalled fetch from a
+// flaky remote fails the step instead of hanging it.
+var gitTimeout time.Duration
+
+// commandWaitDelay bounds the wait for the output of a killed command. git
+// leaves helpers such as git-remote-https behind, which keep its pipes open.
+const commandWaitDelay = 2 * time.Second
+
+// gitEnv holds variables that are only set in the environment of the
+// plugin's git commands, such as their credentials, so that other
+// subprocesses never see them.
+var gitEnv = map[string]string{}
+
+// subprocess is a command bound to its own context, which is released once
+// the command finished.
+type subprocess struct {
+	*exec.Cmd
+	cancel context.CancelFunc
+}
+
+// command prepares a subprocess bound to the context of the run. git
+// commands are killed after gitTimeout.
+func command(name string, arg ...string) *subprocess {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if name == "git" {
+		ctx, cancel = gitContext()
+	} else {
+		ctx, cancel = context.WithCancel(commandCtx)
+	}
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.WaitDelay = commandWaitDelay
+	if name == "git" && len(gitEnv) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range gitEnv {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	cmd.Cancel = func() error {
+		if exceededGitTimeout(ctx) {
+			timedOut.Store(cmd, struct{}{})
+		}
+		return cmd.Process.Kill()
+	}
+	return &subprocess{Cmd: cmd, cancel: cancel}
+}
+
+// Start starts the command and releases its context if that fails.
+func (p *subprocess) Start() error {
+	err := p.Cmd.Start()
+	if err != nil {
+		p.cancel()
+	}
+	return err
+}
+
+// Wait waits for the command to exit and releases its context.
+func (p *subprocess) Wait() error {
+	defer p.cancel()
+	return p.Cmd.Wait()
+}
+
+// Run starts the command, waits for it and releases its context.
+func (p *subprocess) Run() error {
+	defer p.cancel()
+	return p.Cmd.Run()
+}
+
+// Output runs the command, returns its standard output and releases its
+// context.
+func (p *subprocess) Output() ([]byte, error) {
+	defer p.cancel()
+	return p.Cmd.Output()
+}
+
+// timedOut holds the commands gitTimeout killed, since their error only
+// reports the signal.
+var timedOut sync.Map
+
+// gitContext returns the context of a single git operation, limited to
+// gitTimeout when set.
+func gitContext() (context.Context, context.CancelFunc) {
+	if gitTimeout <= 0 {
+		return context.WithCancel(commandCtx)
+	}
+	return context.WithTimeout(commandCtx, gitTimeout)
+}
+
+// parseDuration parses a duration setting such as "30s" or "5m". Empty means
+// no timeout, or the default.
+func parseDuration(setting, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a positive duration such as 30s or 5m", setting, value)
+	}
+	return timeout, nil
+}
+
+// exceededGitTimeout reports whether the context of a git operation ended
+// because of gitTimeout rather than the end of the run.
+func exceededGitTimeout(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded) && commandCtx.Err() == nil
+}
+
+// timeoutError reports a git command that gitTimeout killed, naming its
+// subcommand, e.g. "git fetch did not finish within 30s". Other errors are
+// returned as is.
+func timeoutError(cmd *subprocess, err error) error {
+	if _, ok := timedOut.LoadAndDelete(cmd.Cmd); !ok {
+		return err
+	}
+	return gitTimeoutError(describeCommand(cmd), err)
+}
+
+// gitTimeoutError reports a git operation that gitTimeout stopped.
+func gitTimeoutError(operation string, err error) error {
+	return fmt.Errorf("%s did not finish within %s and was killed: %w", operation, gitTimeout, err)
+}
+
+// describeCommand names a command by its program and subcommand, skipping
+// git's -C and -c options.
+func describeCommand(cmd *subprocess) string {
+	args := cmd.Args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if (args[0] == "-C" || args[0] == "-c") && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return cmd.Args[0]
+	}
+	return cmd.Args[0] + " " + args[0]
+}
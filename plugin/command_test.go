@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGitCredentialsOnlyReachGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	if _, err := exec.LookPath("env"); err != nil {
+		t.Skip("env is not installed")
+	}
+	saved := gitEnv
+	gitEnv = map[string]string{}
+	t.Cleanup(func() { gitEnv = saved })
+
+	creds := []hostCredential{{provider: providerGitHub, host: "github.com", token: "secret-token"}}
+	if err := configureGitCredentials(creds); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := os.LookupEnv(askpassEnv); ok {
+		t.Fatalf("%s is set in the environment of the plugin: %q", askpassEnv, value)
+	}
+
+	output, err := command("env").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{askpassEnv, "GIT_ASKPASS"} {
+		if strings.Contains(string(output), key+"=") {
+			t.Errorf("%s is in the environment of a non-git command", key)
+		}
+	}
+	if strings.Contains(string(output), "secret-token") {
+		t.Error("the token is in the environment of a non-git command")
+	}
+
+	// git passes its own environment on to the shell alias.
+	output, err = command("git", "-c", "alias.show-env=!env", "show-env").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{askpassEnv, "GIT_ASKPASS", "GIT_TERMINAL_PROMPT"} {
+		if !strings.Contains(string(output), key+"=") {
+			t.Errorf("%s is missing from the environment of git", key)
+		}
+	}
+
+	entries, err := askpassCredentials()
+	if err != nil || len(entries) != 1 || entries[0].Token != "secret-token" {
+		t.Errorf("askpassCredentials() = %v, %v, want the configured credential", entries, err)
+	}
+}
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Compare modes supported by compareContent.
+const (
+	compareModeContent = "content"
+	// compareModeOID compares git blob object IDs instead of file contents.
+	compareModeOID = "oid"
+	// compareModeSHA256 streams both sides through SHA-256 and compares digests.
+	compareModeSHA256 = "sha256"
+	// compareModeYAML compares the parsed YAML structures.
+	compareModeYAML = "yaml"
+	// compareModeJSON compares canonicalized JSON.
+	compareModeJSON = "json"
+	// compareModeContains passes when the trusted lines are all kept in the
+	// current file, which may add lines of its own.
+	compareModeContains = "contains"
+)
+
+// compareOptions controls how a single file is compared against its trusted version.
+type compareOptions struct {
+	Mode             string   `yaml:"compare_mode"`
+	NormalizeEOL     bool     `yaml:"normalize_eol"`
+	IgnoreWhitespace bool     `yaml:"ignore_whitespace"`
+	IgnoreMarkers    bool     `yaml:"ignore_markers"`
+	IgnorePatterns   []string `yaml:"ignore_patterns"`
+	StripComments    bool     `yaml:"strip_comments"`
+	BOM              string   `yaml:"bom"`
+	RenderEnv        bool     `yaml:"render_env"`
+	RenderEnvVars    []string `yaml:"render_env_vars"`
+	// SOPS decrypts both sides with sops before comparing.
+	SOPS bool `yaml:"sops"`
+	// Age decrypts both sides with age before comparing.
+	Age bool `yaml:"age"`
+	// Variants are additional trusted files the current file may match instead.
+	Variants []string `yaml:"trusted_variants"`
+}
+
+// defaultCompareOptions builds the compare options configured through settings.
+func defaultCompareOptions(args Args) compareOptions {
+	return compareOptions{
+		Mode:             args.CompareMode,
+		NormalizeEOL:     args.NormalizeEOL,
+		IgnoreWhitespace: args.IgnoreWhitespace,
+		IgnoreMarkers:    args.IgnoreMarkers,
+		IgnorePatterns:   splitLines(args.IgnorePatterns),
+		StripComments:    args.StripComments,
+		BOM:              args.BOMPolicy,
+		RenderEnv:        args.RenderEnv,
+		RenderEnvVars:    splitList(args.RenderEnvVars),
+		SOPS:             args.SOPS,
+		Age:              args.Age,
+		Variants:         splitList(args.TrustedFilePaths),
+	}
+}
+
+// validate checks that the options are supported.
+func (o compareOptions) validate() error {
+	switch o.Mode {
+	case "", compareModeContent, compareModeOID, compareModeSHA256, compareModeYAML, compareModeJSON, compareModeContains:
+	default:
+		return fmt.Errorf("unsupported compare mode %q", o.Mode)
+	}
+	switch o.BOM {
+	case "", bomPolicyStrip, bomPolicyFail:
+	default:
+		return fmt.Errorf("unsupported bom policy %q, expected strip or fail", o.BOM)
+	}
+	for _, p := range o.IgnorePatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid ignore pattern %q: %w", p, err)
+		}
+	}
+	if len(o.RenderEnvVars) > 0 && !o.RenderEnv {
+		return fmt.Errorf("render_env_vars requires render_env")
+	}
+	if !o.readsContent() && o.SOPS {
+		return fmt.Errorf("sops cannot be used with compare mode %q", o.Mode)
+	}
+	if !o.readsContent() && o.Age {
+		return fmt.Errorf("age cannot be used with compare mode %q", o.Mode)
+	}
+	if o.SOPS && o.Age {
+		return fmt.Errorf("sops and age cannot be combined")
+	}
+	if !o.readsContent() && len(o.normalizers()) > 0 {
+		return fmt.Errorf("normalization options cannot be used with compare mode %q", o.Mode)
+	}
+	return nil
+}
+
+// readsContent reports whether the options require the file contents.
+func (o compareOptions) readsContent() bool {
+	return o.Mode != compareModeOID && o.Mode != compareModeSHA256
+}
+
+// compareContent reports whether the current content of filePath is trusted
+// according to opts. Enabled normalizations are applied to both sides first.
+func compareContent(filePath string, opts compareOptions, trusted, current string) (bool, error) {
+	// Normalizations and structured parsing are meaningless for binary files.
+	if isBinary(trusted) || isBinary(current) {
+		logrus.Infof("File %s is binary, comparing digests", filePath)
+		return sha256Hex(trusted) == sha256Hex(current), nil
+	}
+
+	hasTrustedBOM, hasCurrentBOM := strings.HasPrefix(trusted, utf8BOM), strings.HasPrefix(current, utf8BOM)
+	if hasTrustedBOM != hasCurrentBOM {
+		if opts.BOM == bomPolicyFail {
+			logrus.Warnf("File %s has a UTF-8 byte order mark on only one side", filePath)
+			return false, nil
+		}
+		if opts.BOM == "" {
+			logrus.Infof("File %s has a UTF-8 byte order mark on only one side; set bom_policy to strip to ignore it", filePath)
+		}
+	}
+
+	for _, n := range opts.normalizers() {
+		normalizedTrusted, normalizedCurrent := n.apply(trusted), n.apply(current)
+		if normalizedTrusted != trusted || normalizedCurrent != current {
+			logrus.Infof("Normalized %s in %s before comparison", n.name, filePath)
+		}
+		trusted, current = normalizedTrusted, normalizedCurrent
+	}
+
+	switch opts.Mode {
+	case "", compareModeContent:
+		return trusted == current, nil
+	case compareModeYAML:
+		return compareYAML(filePath, trusted, current)
+	case compareModeJSON:
+		return compareJSON(filePath, trusted, current)
+	case compareModeContains:
+		return compareContains(filePath, trusted, current), nil
+	default:
+		return false, fmt.Errorf("unsupported compare mode %q", opts.Mode)
+	}
+}
+
+// compareContains reports whether every trusted line is kept, in order, in the
+// current content, i.e. the diff from trusted to current only adds lines.
+func compareContains(filePath, trusted, current string) bool {
+	var missing []string
+	for _, op := range diffLines(splitLinesKeepEmpty(trusted), splitLinesKeepEmpty(current)) {
+		if op.Kind == diffDelete {
+			missing = append(missing, op.Line)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	logrus.Warnf("File %s is missing %d trusted line(s), first: %q", filePath, len(missing), missing[0])
+	return false
+}
+
+// binarySniffLen is how much of a file git inspects when deciding whether it is binary.
+const binarySniffLen = 8000
+
+// isBinary applies git's heuristic: content with a NUL byte in its first
+// 8000 bytes is binary.
+func isBinary(content string) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return strings.IndexByte(content, 0) >= 0
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cosignPolicy verifies each trusted file with cosign verify-blob against a
+// signature stored next to it on the trusted ref: a <file>.bundle, or a
+// <file>.sig with a <file>.pem certificate for keyless signing. Either key or
+// identity and issuer must be set.
+type cosignPolicy struct {
+	// key is a public key in PEM format, a path or a KMS URI.
+	key      string
+	identity string
+	issuer   string
+}
+
+func (p cosignPolicy) check(repoPath, ref, filePath string) (string, error) {
+	dir, err := os.MkdirTemp("", "trusted-cosign-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// readCompanion copies a file from the trusted ref into the temporary
+	// directory, returning an empty path when it does not exist.
+	readCompanion := func(name string) (string, error) {
+		content, found, err := readOptionalTrustedFile(repoPath, ref, name)
+		if err != nil || !found {
+			return "", err
+		}
+		target := filepath.Join(dir, filepath.Base(name))
+		return target, os.WriteFile(target, []byte(content), 0600)
+	}
+
+	content, err := readTrustedFile(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	blob := filepath.Join(dir, filepath.Base(filePath))
+	if err := os.WriteFile(blob, []byte(content), 0600); err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	args := []string{"verify-blob"}
+	if bundle, err := readCompanion(filePath + ".bundle"); err != nil {
+		return "", err
+	} else if bundle != "" {
+		args = append(args, "--bundle", bundle)
+	} else {
+		signature, err := readCompanion(filePath + ".sig")
+		if err != nil {
+			return "", err
+		}
+		if signature == "" {
+			return fmt.Sprintf("no cosign signature %s.sig or %s.bundle on the trusted branch", filePath, filePath), nil
+		}
+		args = append(args, "--signature", signature)
+		if certificate, err := readCompanion(filePath + ".pem"); err != nil {
+			return "", err
+		} else if certificate != "" {
+			args = append(args, "--certificate", certificate)
+		}
+	}
+
+	if p.key != "" {
+		key := p.key
+		if strings.HasPrefix(key, "-----BEGIN") {
+			key = filepath.Join(dir, "cosign.pub")
+			if err := os.WriteFile(key, []byte(p.key), 0600); err != nil {
+				return "", fmt.Errorf("failed to write cosign key: %w", err)
+			}
+		}
+		args = append(args, "--key", key)
+	} else {
+		args = append(args, "--certificate-identity", p.identity, "--certificate-oidc-issuer", p.issuer)
+	}
+	args = append(args, blob)
+
+	var stderr bytes.Buffer
+	cmd := command("cosign", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logrus.Warnf("cosign verification of %s failed: %v", filePath, commandError(err, stderr.String()))
+		return fmt.Sprintf("cosign signature of %s is not valid", filePath), nil
+	}
+	logrus.Infof("File %s has a valid cosign signature", filePath)
+	return "", nil
+}
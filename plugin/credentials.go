@@ -0,0 +1,249 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// hostCredential is a token git sends to the HTTPS remotes of one host.
+type hostCredential struct {
+	provider string
+	host     string
+	username string
+	token    string
+}
+
+// gitCredentials collects the credentials to store for git: the entries of
+// git_credentials first, so they take precedence, then git_pat or a GitLab
+// CI job token for the host of origin and the hosts in git_hosts, and last
+// the clone credentials Drone injects as DRONE_NETRC_*.
+func gitCredentials(provider, host string, args Args) ([]hostCredential, error) {
+	creds, err := parseGitCredentials(args.GitCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	username, token := args.GitUsername, args.GitPat
+	if jobToken := os.Getenv("CI_JOB_TOKEN"); token == "" && jobToken != "" && provider == providerGitLab {
+		// In a GitLab CI job, the job token can fetch the trusted ref.
+		provider, username, token = providerGitLab, gitlabJobTokenUser, jobToken
+	}
+	if token != "" {
+		for i, h := range credentialHosts(host, args.GitHosts) {
+			if i == 0 {
+				creds = append(creds, hostCredential{provider: provider, host: h, username: username, token: token})
+				continue
+			}
+			// The username for additional hosts is detected from their name.
+			creds = append(creds, hostCredential{provider: detectProvider("https://" + h), host: h, token: token})
+		}
+	}
+
+	if machine, password := os.Getenv("DRONE_NETRC_MACHINE"), os.Getenv("DRONE_NETRC_PASSWORD"); machine != "" && password != "" {
+		creds = append(creds, hostCredential{
+			provider: detectProvider("https://" + machine),
+			host:     machine,
+			username: os.Getenv("DRONE_NETRC_USERNAME"),
+			token:    password,
+		})
+	}
+	return creds, nil
+}
+
+// parseGitCredentials parses the git_credentials setting, a list of
+// "host=token" or "host=username:token" entries for hosts that need a
+// different token than git_pat, such as an internal mirror.
+func parseGitCredentials(value string) ([]hostCredential, error) {
+	var creds []hostCredential
+	for _, entry := range splitList(value) {
+		host, secret, ok := strings.Cut(entry, "=")
+		host = strings.TrimSpace(host)
+		if !ok || host == "" || strings.TrimSpace(secret) == "" {
+			// The entry holds a token, so it is never included in errors.
+			return nil, fmt.Errorf("invalid git_credentials entry, expected host=token or host=username:token")
+		}
+		cred := hostCredential{provider: detectProvider("https://" + host), host: host, token: strings.TrimSpace(secret)}
+		if username, token, ok := strings.Cut(cred.token, ":"); ok {
+			cred.username, cred.token = username, token
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+// askpassEnv holds the credentials, as JSON, in the environment of the git
+// commands the plugin runs. It also tells the plugin binary that git started
+// it as GIT_ASKPASS.
+const askpassEnv = "DRONE_READ_TRUSTED_ASKPASS"
+
+// askpassCredential is the answer to git's prompts for one host.
+type askpassCredential struct {
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// configureGitCredentials makes the plugin binary the GIT_ASKPASS helper of
+// its own git commands and hands it the credentials through their
+// environment. Nothing is written to disk, to the global git config or to the
+// environment of the plugin, so the tokens do not outlive the plugin or leak
+// into later steps or other tools it runs.
+func configureGitCredentials(creds []hostCredential) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var entries []askpassCredential
+	for _, cred := range creds {
+		entries = append(entries, askpassCredential{
+			Host:     cred.host,
+			Username: credentialUsername(cred.provider, cred.host, cred.username),
+			Token:    cred.token,
+		})
+	}
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	gitEnv[askpassEnv] = string(encoded)
+	gitEnv["GIT_ASKPASS"] = exe
+	gitEnv["GIT_TERMINAL_PROMPT"] = "0"
+	return nil
+}
+
+// IsAskPass reports whether git started the plugin binary as GIT_ASKPASS,
+// with the prompt as the only argument.
+func IsAskPass(args []string) bool {
+	return len(args) == 1 && os.Getenv(askpassEnv) != ""
+}
+
+// AskPass answers a git credential prompt such as "Username for
+// 'https://github.com': " or "Password for 'https://user@github.com': " with
+// the first credential for the host in the prompt.
+func AskPass(prompt string, w io.Writer) error {
+	entries, err := askpassCredentials()
+	if err != nil {
+		return err
+	}
+	_, quoted, _ := strings.Cut(prompt, "'")
+	quoted, _, _ = strings.Cut(quoted, "'")
+	u, err := url.Parse(quoted)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("unexpected credential prompt")
+	}
+	entry, ok := findCredential(entries, u.Hostname())
+	if !ok {
+		return fmt.Errorf("no credentials for %s", u.Hostname())
+	}
+	if strings.HasPrefix(prompt, "Username") {
+		_, err = fmt.Fprintln(w, entry.Username)
+	} else {
+		_, err = fmt.Fprintln(w, entry.Token)
+	}
+	return err
+}
+
+// askpassCredentials returns the credentials configureGitCredentials handed
+// to git, or none when it was not called. Started as GIT_ASKPASS, the plugin
+// finds them in its environment.
+func askpassCredentials() ([]askpassCredential, error) {
+	value, ok := gitEnv[askpassEnv]
+	if !ok {
+		value = os.Getenv(askpassEnv)
+	}
+	if value == "" {
+		return nil, nil
+	}
+	var entries []askpassCredential
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", askpassEnv, err)
+	}
+	return entries, nil
+}
+
+// findCredential returns the first credential for the host.
+func findCredential(entries []askpassCredential, host string) (askpassCredential, bool) {
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Host, host) {
+			return entry, true
+		}
+	}
+	return askpassCredential{}, false
+}
+
+// writeNetrc writes the credentials to ~/.netrc, which git reads through
+// libcurl, for setups that standardize on netrc. Existing entries are kept
+// after the plugin's, and the original file is restored by the returned
+// function.
+func writeNetrc(creds []hostCredential) (func(), error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	netrcPath := filepath.Join(home, ".netrc")
+	original, err := os.ReadFile(netrcPath)
+	existed := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", netrcPath, err)
+	}
+
+	var content strings.Builder
+	for _, cred := range creds {
+		fmt.Fprintf(&content, "machine %s login %s password %s\n",
+			cred.host, credentialUsername(cred.provider, cred.host, cred.username), cred.token)
+	}
+	content.Write(original)
+	restore := func() {
+		if existed {
+			os.WriteFile(netrcPath, original, 0600)
+		} else {
+			os.Remove(netrcPath)
+		}
+	}
+	if err := os.WriteFile(netrcPath, []byte(content.String()), 0600); err != nil {
+		restore()
+		return nil, fmt.Errorf("failed to write %s: %w", netrcPath, err)
+	}
+	return restore, nil
+}
+
+// credentialHosts returns the host of origin followed by the additional
+// hosts of the comma separated git_hosts setting, without duplicates.
+func credentialHosts(host, gitHosts string) []string {
+	hosts := []string{host}
+	for _, h := range strings.Split(gitHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" && !slices.Contains(hosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// credentialUsername returns the username sent with a token. An explicit
+// username is kept, e.g. the account of a Bitbucket app password; otherwise
+// the username the provider expects with a bare token is used.
+func credentialUsername(provider, host, username string) string {
+	if username != "" {
+		return username
+	}
+	switch {
+	case provider == providerGitLab:
+		return "oauth2"
+	case provider == providerBitbucket:
+		// Repository, project and workspace access tokens.
+		return "x-token-auth"
+	case provider == providerAzure || isAzureHost(host):
+		// Azure DevOps takes the PAT as the basic auth password and ignores
+		// the username.
+		return "pat"
+	}
+	return "x-access-token"
+}
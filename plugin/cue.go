@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	cueerrors "cuelang.org/go/cue/errors"
+	cuejson "cuelang.org/go/encoding/json"
+	cueyaml "cuelang.org/go/encoding/yaml"
+)
+
+// cuePolicy validates the trusted version of each file against a CUE schema,
+// so downstream steps never receive structurally invalid config. The schema
+// is evaluated in process, like cue vet, and the file format is picked from
+// the file's extension.
+type cuePolicy struct {
+	schemaPath string
+	schema     string
+	// definition optionally names the definition to validate against, e.g. #Config.
+	definition string
+}
+
+// newCUEPolicy reads the CUE schema from the trusted ref.
+func newCUEPolicy(repoPath, ref, schemaPath, definition string) (cuePolicy, error) {
+	if _, err := resolveInRepo(repoPath, schemaPath); err != nil {
+		return cuePolicy{}, err
+	}
+	schema, err := readTrustedFile(repoPath, ref, schemaPath)
+	if err != nil {
+		return cuePolicy{}, fmt.Errorf("failed to read cue schema: %w", err)
+	}
+	return cuePolicy{schemaPath: schemaPath, schema: schema, definition: definition}, nil
+}
+
+// check compiles the schema for every file, since a CUE context must not be
+// shared between the workers verifying files concurrently.
+func (p cuePolicy) check(repoPath, ref, filePath string) (string, error) {
+	content, err := readTrustedFile(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := cuecontext.New()
+	schema := ctx.CompileString(p.schema, cue.Filename(p.schemaPath))
+	if err := schema.Err(); err != nil {
+		return "", fmt.Errorf("failed to compile cue schema %s: %w", p.schemaPath, err)
+	}
+	if p.definition != "" {
+		schema = schema.LookupPath(cue.ParsePath(p.definition))
+		if !schema.Exists() {
+			return "", fmt.Errorf("cue schema %s has no definition %s", p.schemaPath, p.definition)
+		}
+	}
+
+	var data cue.Value
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		expr, err := cuejson.Extract(filePath, []byte(content))
+		if err != nil {
+			return cueReason(err), nil
+		}
+		data = ctx.BuildExpr(expr)
+	case ".yaml", ".yml":
+		file, err := cueyaml.Extract(filePath, []byte(content))
+		if err != nil {
+			return cueReason(err), nil
+		}
+		data = ctx.BuildFile(file)
+	case ".cue":
+		data = ctx.CompileString(content, cue.Filename(filePath))
+	default:
+		return "", fmt.Errorf("cue_schema supports JSON, YAML and CUE files, not %s", filePath)
+	}
+	if err := data.Err(); err != nil {
+		return cueReason(err), nil
+	}
+	if err := schema.Unify(data).Validate(cue.Concrete(true)); err != nil {
+		return cueReason(err), nil
+	}
+	return "", nil
+}
+
+// cueReason reports the validation errors of a file.
+func cueReason(err error) string {
+	message := strings.TrimSpace(cueerrors.Details(err, nil))
+	return fmt.Sprintf("does not match the cue schema: %s", Redact(message))
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"fmt"
+)
+
+// Policies for files that were deleted or renamed on the current branch.
+const (
+	deletedPolicyFail     = "fail"
+	deletedPolicyWarn     = "warn"
+	deletedPolicyMismatch = "mismatch"
+)
+
+const reasonDeleted = "deleted on current branch"
+
+// validateDeletedPolicy checks the configured deleted file policy.
+func validateDeletedPolicy(policy string) error {
+	switch policy {
+	case deletedPolicyFail, deletedPolicyWarn, deletedPolicyMismatch:
+		return nil
+	default:
+		return fmt.Errorf("unsupported deleted_file_policy %q, expected fail, warn or mismatch", policy)
+	}
+}
+
+// describeMissingFile explains why a file that exists on the trusted branch is
+// missing from the workspace, using git rename detection against the trusted ref.
+func describeMissingFile(repoPath, branch, filePath string) string {
+	ref := branch
+	if !refExists(repoPath, branch) {
+		ref = fetchedRef(branch)
+	}
+
+	if renamed, ok := gitOps.renamedTo(repoPath, ref, filePath); ok {
+		return fmt.Sprintf("renamed to %s on current branch", renamed)
+	}
+	return reasonDeleted
+}
@@ -0,0 +1,251 @@
+package plugin
+
+import "strings"
+
+// Kinds of line edits produced by diffLines.
+const (
+	diffEqual = iota
+	diffInsert
+	diffDelete
+)
+
+// diffOp is a single line of an edit script turning one text into another.
+type diffOp struct {
+	Kind int
+	Line string
+}
+
+// splitLinesKeepEmpty splits content into lines without dropping blank lines.
+// A trailing newline does not produce an extra empty line.
+func splitLinesKeepEmpty(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines computes a minimal line edit script from a to b using the
+// linear space variant of the Myers O(ND) algorithm, so large files with many
+// changed lines, e.g. after a line ending change, do not exhaust memory.
+//
+// Lines that occur on one side only can never match, so they are removed
+// before the search and put back as deletions and insertions afterwards.
+// This keeps the search small when most lines changed.
+func diffLines(a, b []string) []diffOp {
+	keptA, keptB := linesIn(a, b), linesIn(b, a)
+	sa, sb := make([]string, len(keptA)), make([]string, len(keptB))
+	for i, index := range keptA {
+		sa[i] = a[index]
+	}
+	for i, index := range keptB {
+		sb[i] = b[index]
+	}
+	size := 2*((len(sa)+len(sb)+1)/2) + 3
+	d := &differ{forward: make([]int, size), backward: make([]int, size)}
+	d.diffRange(sa, sb)
+
+	var ops []diffOp
+	i, j := 0, 0
+	deleteUpTo := func(end int) {
+		for ; i < end; i++ {
+			ops = append(ops, diffOp{Kind: diffDelete, Line: a[i]})
+		}
+	}
+	insertUpTo := func(end int) {
+		for ; j < end; j++ {
+			ops = append(ops, diffOp{Kind: diffInsert, Line: b[j]})
+		}
+	}
+	ia, ib := 0, 0
+	for _, op := range d.ops {
+		switch op.Kind {
+		case diffEqual:
+			deleteUpTo(keptA[ia])
+			insertUpTo(keptB[ib])
+			ops = append(ops, op)
+			i, j = i+1, j+1
+			ia, ib = ia+1, ib+1
+		case diffDelete:
+			deleteUpTo(keptA[ia] + 1)
+			ia++
+		case diffInsert:
+			insertUpTo(keptB[ib] + 1)
+			ib++
+		}
+	}
+	deleteUpTo(len(a))
+	insertUpTo(len(b))
+	return ops
+}
+
+// linesIn returns the indexes of the lines of a that also occur in b.
+func linesIn(a, b []string) []int {
+	inB := make(map[string]bool, len(b))
+	for _, line := range b {
+		inB[line] = true
+	}
+	var kept []int
+	for i, line := range a {
+		if inB[line] {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// differ holds the edit script being built and the frontiers of the middle
+// snake searches, which are reused by every level of the recursion.
+type differ struct {
+	ops      []diffOp
+	forward  []int
+	backward []int
+}
+
+// diffRange appends the edit script from a to b. Common leading and trailing
+// lines are matched directly and the rest is split at the middle snake of its
+// shortest edit path.
+func (d *differ) diffRange(a, b []string) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		d.ops = append(d.ops, diffOp{Kind: diffEqual, Line: a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			d.ops = append(d.ops, diffOp{Kind: diffInsert, Line: line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			d.ops = append(d.ops, diffOp{Kind: diffDelete, Line: line})
+		}
+	default:
+		x, y := d.middleSnake(a, b)
+		d.diffRange(a[:x], b[:y])
+		d.diffRange(a[x:], b[y:])
+	}
+	for _, line := range common {
+		d.ops = append(d.ops, diffOp{Kind: diffEqual, Line: line})
+	}
+}
+
+// middleSnake runs the forward and backward searches for the shortest edit
+// path from a to b until they overlap and returns the point where they meet.
+// Both need only one frontier of furthest reaching x per diagonal. a and b are
+// non-empty and differ in their first and last lines.
+func (d *differ) middleSnake(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	forward := d.forward[:2*maxD+3]
+	backward := d.backward[:2*maxD+3]
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	// With an odd delta the paths meet during a forward step, otherwise
+	// during a backward step.
+	odd := delta%2 != 0
+
+	for cost := 0; cost <= maxD; cost++ {
+		for k := -cost; k <= cost; k += 2 {
+			var x int
+			if k == -cost || (k != cost && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			if x < 0 || x > n || y < 0 || y > m {
+				continue
+			}
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// The backward diagonal ending on this one.
+			if i := offset + delta - k; odd && i >= 0 && i < len(backward) && backward[i] >= 0 && x+backward[i] >= n {
+				return x, y
+			}
+		}
+		for k := -cost; k <= cost; k += 2 {
+			var x int
+			if k == -cost || (k != cost && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			if x < 0 || x > n || y < 0 || y > m {
+				continue
+			}
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if i := offset + delta - k; !odd && i >= 0 && i < len(forward) && forward[i] >= 0 && forward[i]+x >= n {
+				return forward[i], forward[i] - delta + k
+			}
+		}
+	}
+	// Unreachable: the searches meet by the time cost reaches half the
+	// distance.
+	return n, 0
+}
+
+// maxDiffStatLines bounds the lines of both versions for which the changed
+// line counts of a mismatch are computed. The search takes time proportional
+// to the lines times the changes, so files beyond it are too large to diff.
+const maxDiffStatLines = 10000
+
+// tooLargeToDiff reports whether counting the changed lines between two
+// versions would take too long.
+func tooLargeToDiff(trusted, current string) bool {
+	return strings.Count(trusted, "\n")+strings.Count(current, "\n") > maxDiffStatLines
+}
+
+// diffContextLines matches git's default amount of unified diff context.
+const diffContextLines = 3
+
+// diffStats summarizes an edit script like `git diff --stat` would.
+type diffStats struct {
+	Added   int
+	Removed int
+	Hunks   int
+}
+
+// computeDiffStats counts added and removed lines and the number of hunks git
+// would print. Changes separated by fewer than 2*3 unchanged lines share a hunk.
+func computeDiffStats(trusted, current string) diffStats {
+	var stats diffStats
+	equalRun := 0
+	inHunk := false
+	for _, op := range diffLines(splitLinesKeepEmpty(trusted), splitLinesKeepEmpty(current)) {
+		if op.Kind == diffEqual {
+			equalRun++
+			continue
+		}
+		if !inHunk || equalRun > 2*diffContextLines {
+			stats.Hunks++
+		}
+		inHunk = true
+		equalRun = 0
+		if op.Kind == diffInsert {
+			stats.Added++
+		} else {
+			stats.Removed++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// diffDirectory compares the files under dirPath on the trusted branch with
+// the files present in the workspace, ignoring excluded files. It returns the
+// files found on both sides and those that were added or removed on the
+// current branch.
+func diffDirectory(repoPath, branch, dirPath string, exclude excluder) (common, added, removed []string, err error) {
+	dir := path.Clean(filepath.ToSlash(dirPath))
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	trustedFiles, err := listTrustedFiles(repoPath, branch)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	trusted := make(map[string]bool)
+	for _, f := range trustedFiles {
+		if strings.HasPrefix(f, prefix) && !exclude.excluded(f) {
+			trusted[f] = true
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, nil, nil, fmt.Errorf("directory %s does not exist on trusted branch %s or all of its files are excluded", dirPath, branch)
+	}
+
+	current := make(map[string]bool)
+	root := filepath.Join(repoPath, filepath.FromSlash(dir))
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(repoPath, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); !exclude.excluded(rel) {
+			current[rel] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to walk directory %s: %w", root, err)
+	}
+
+	for f := range trusted {
+		if current[f] {
+			common = append(common, f)
+		} else {
+			removed = append(removed, f)
+		}
+	}
+	for f := range current {
+		if !trusted[f] {
+			added = append(added, f)
+		}
+	}
+	sort.Strings(common)
+	sort.Strings(added)
+	sort.Strings(removed)
+	return common, added, removed, nil
+}
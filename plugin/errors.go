@@ -0,0 +1,16 @@
+package plugin
+
+import "fmt"
+
+// MissingTrustedFileError is returned when a file to verify does not exist on
+// the trusted branch.
+type MissingTrustedFileError struct {
+	Path   string
+	Branch string
+}
+
+func (e *MissingTrustedFileError) Error() string {
+	return fmt.Sprintf("file %s does not exist on trusted branch %s: "+
+		"check that file_path is relative to the repository root and that the file has been committed to %s",
+		e.Path, e.Branch, e.Branch)
+}
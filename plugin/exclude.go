@@ -0,0 +1,88 @@
+package plugin
+
+import "strings"
+
+// excludeRule is a single gitignore-style pattern.
+type excludeRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// excluder decides which files are skipped by directory and glob verification.
+// Like .gitignore, the last matching rule wins and "!" re-includes a file.
+type excluder []excludeRule
+
+// parseExcludes builds an excluder from gitignore-style patterns.
+func parseExcludes(patterns []string) excluder {
+	var rules excluder
+	for _, p := range patterns {
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		var rule excludeRule
+		if strings.HasPrefix(p, "!") {
+			rule.negate = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			rule.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if strings.Contains(p, "/") {
+			rule.anchored = true
+			p = strings.TrimPrefix(p, "/")
+		}
+		if p == "" {
+			continue
+		}
+		rule.pattern = p
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// excluded reports whether the slash separated file path is excluded.
+func (e excluder) excluded(file string) bool {
+	excluded := false
+	for _, rule := range e {
+		if rule.matches(file) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// matches reports whether the rule matches the file or one of its parent directories.
+func (r excludeRule) matches(file string) bool {
+	pattern := r.pattern
+	if !r.anchored {
+		pattern = "**/" + pattern
+	}
+	segments := strings.Split(file, "/")
+	for i := 1; i <= len(segments); i++ {
+		isFile := i == len(segments)
+		if isFile && r.dirOnly {
+			break
+		}
+		if matchGlob(pattern, strings.Join(segments[:i], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the files that are not excluded.
+func (e excluder) filter(files []string) []string {
+	if len(e) == 0 {
+		return files
+	}
+	var kept []string
+	for _, f := range files {
+		if !e.excluded(f) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseExpectedDigests maps files to their pinned SHA-256 digests. The setting
+// is either a single digest for a single file_path, or a list of
+// "path=digest" entries.
+func parseExpectedDigests(expected string, filePaths []string) (map[string]string, []string, error) {
+	return parseExpectedValues("expected_sha256", "SHA-256", expected, filePaths)
+}
+
+// parseExpectedValues parses a setting holding one hex encoded SHA-256 sized
+// value per file, named kind in errors. It returns the values by path and the
+// paths in order.
+func parseExpectedValues(setting, kind, expected string, filePaths []string) (map[string]string, []string, error) {
+	entries := splitList(expected)
+	values := make(map[string]string)
+	var order []string
+
+	if len(entries) == 1 && !strings.Contains(entries[0], "=") {
+		if len(filePaths) != 1 {
+			return nil, nil, fmt.Errorf("%s without paths requires exactly one file_path", setting)
+		}
+		entries = []string{filePaths[0] + "=" + entries[0]}
+	}
+
+	for _, entry := range entries {
+		p, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid %s entry %q, expected path=value", setting, entry)
+		}
+		p, err := cleanRelativePath(strings.TrimSpace(p))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s entry %q: %w", setting, entry, err)
+		}
+		if isGlob(p) {
+			return nil, nil, fmt.Errorf("invalid %s entry %q: glob patterns are not supported", setting, entry)
+		}
+		value = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "sha256:"))
+		if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != 32 {
+			return nil, nil, fmt.Errorf("invalid %s entry %q: not a %s hex value", setting, entry, kind)
+		}
+		if _, ok := values[p]; !ok {
+			order = append(order, p)
+		}
+		values[p] = value
+	}
+	return values, order, nil
+}
+
+// verifyExpectedDigests compares workspace files against pinned SHA-256
+// digests without consulting the trusted branch at all.
+func verifyExpectedDigests(repoPath string, digests map[string]string, order []string) ([]fileResult, error) {
+	var results []fileResult
+	for _, filePath := range order {
+		fullPath, err := resolveInRepo(repoPath, filePath)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := sha256File(fullPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			logrus.Warnf("File %s does not exist on the current branch", filePath)
+			results = append(results, fileResult{Path: filePath, Reason: reasonDeleted})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", fullPath, err)
+		}
+
+		result := fileResult{Path: filePath, SHA256: digest, Matched: digest == digests[filePath]}
+		if result.Matched {
+			logrus.Infof("File %s matches the expected SHA-256 digest", filePath)
+		} else {
+			logrus.Warnf("File %s has SHA-256 %s, expected %s", filePath, digest, digests[filePath])
+			result.Reason = reasonDigestDiffers
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// readWorkspaceFile reads a file from the workspace after validating its path.
+func readWorkspaceFile(repoPath, filePath string) (string, error) {
+	fullPath, err := resolveInRepo(repoPath, filePath)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", fullPath, err)
+	}
+	return string(content), nil
+}
@@ -0,0 +1,309 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Backends for the git operations that read trusted files.
+const (
+	// gitBackendExec runs the git binary.
+	gitBackendExec = "exec"
+	// gitBackendGoGit uses go-git, for containers without a git binary.
+	gitBackendGoGit = "go-git"
+)
+
+// gitBackend fetches trusted refs from origin and reads their trees.
+type gitBackend interface {
+	// fetch fetches the branch, tag or commit from origin, limited to depth
+	// commits from its tip when depth is positive.
+	fetch(repoPath, ref string, depth int) error
+	// show returns the content of a file in the tree of ref.
+	show(repoPath, ref, filePath string) (string, error)
+	// listTree lists every file in the tree of ref.
+	listTree(repoPath, ref string) ([]string, error)
+	// hasRef reports whether ref resolves to a commit.
+	hasRef(repoPath, ref string) bool
+	// hasFile reports whether the file exists in the tree of ref.
+	hasFile(repoPath, ref, filePath string) bool
+	// revParse resolves a revision, rev^{commit} or rev:path, to its object
+	// ID.
+	revParse(repoPath, rev string) (string, error)
+	// currentBranch returns the checked out branch, or HEAD when detached.
+	currentBranch(repoPath string) (string, error)
+	// hashObject computes the blob object ID of a workspace file.
+	hashObject(repoPath, filePath string) (string, error)
+	// unmodified reports whether the files in the workspace match HEAD.
+	unmodified(repoPath string, files []string) bool
+	// isShallow reports whether the repository is a shallow clone.
+	isShallow(repoPath string) bool
+	// originURL returns the URL of the origin remote as configured.
+	originURL(repoPath string) (string, error)
+	// defaultBranch returns the default branch of origin, from the local
+	// origin/HEAD or by asking origin.
+	defaultBranch(repoPath string) (string, error)
+	// hasRemoteTag reports whether origin has a tag with the given name.
+	hasRemoteTag(repoPath, tag string) bool
+	// openFile streams the content of a file in the tree of ref.
+	openFile(repoPath, ref, filePath string) (io.ReadCloser, error)
+	// fileSize returns the size of a file in the tree of ref without
+	// reading it.
+	fileSize(repoPath, ref, filePath string) (int64, error)
+	// renamedTo returns the path a file in the tree of ref was renamed to
+	// on the current branch, if it was.
+	renamedTo(repoPath, ref, filePath string) (string, bool)
+}
+
+// gitOps is the backend selected by the git_backend setting.
+var gitOps gitBackend = execGit{}
+
+// selectGitBackend parses the git_backend setting. auto, the default, runs
+// the git binary when it is installed and go-git otherwise.
+func selectGitBackend(name string) (gitBackend, error) {
+	switch name {
+	case "", "auto":
+		if !gitInstalled() {
+			return goGit{}, nil
+		}
+		return execGit{}, nil
+	case gitBackendExec:
+		return execGit{}, nil
+	case gitBackendGoGit:
+		return goGit{}, nil
+	}
+	return nil, fmt.Errorf("invalid git_backend %q: expected auto, exec or go-git", name)
+}
+
+// gitInstalled reports whether the git binary is on the PATH.
+func gitInstalled() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+// usesGitBinary reports whether trusted files are read with the git binary,
+// which the heavyweight checkout and the plugin's git config require.
+func usesGitBinary() bool {
+	_, ok := gitOps.(execGit)
+	return ok
+}
+
+// gitBinarySettings returns the settings whose checks inspect history or
+// list the refs of origin, which only the git binary does. go-git runs them
+// with the git binary when it is installed.
+func gitBinarySettings(args Args) []string {
+	var settings []string
+	for _, ref := range splitList(args.TrustedRef) {
+		if isGlob(ref) {
+			settings = append(settings, "trusted_ref patterns")
+			break
+		}
+	}
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{"trusted_semver", args.TrustedSemver != ""},
+		{"merge_base", args.MergeBase},
+		{"allow_historical", args.AllowHistorical},
+		{"skip_untouched", args.SkipUntouched},
+		{"require_ancestor", args.RequireAncestor},
+		{"max_age_days", args.MaxAgeDays > 0},
+		{"trusted_authors", args.TrustedAuthors != ""},
+		{"require_signed_commits", args.RequireSigned},
+		{"require_gpg_signature", args.RequireGPGSig},
+		{"ssh_allowed_signers", args.SSHAllowedSigners != ""},
+		{"approval_team", args.ApprovalTeam != ""},
+		{"require_codeowner_approval", args.RequireCodeowners},
+	} {
+		if setting.set {
+			settings = append(settings, setting.name)
+		}
+	}
+	return settings
+}
+
+// execGit runs the git binary.
+type execGit struct{}
+
+// fetch force updates tags, since the remote is the source of truth.
+// Branches are fetched into their remote-tracking branch explicitly, since
+// single-branch clones have no refspec for them. A complete clone is never
+// made shallow. Blobs are left out of the fetch when partialFetch is set and
+// the workspace already is a partial clone, and git fetches those of the
+// files it reads on demand. Filtering a fetch into any other clone would
+// turn it into a partial clone for the later steps too.
+func (g execGit) fetch(repoPath, ref string, depth int) error {
+	refspec := ref
+	switch {
+	case isTagRef(ref):
+		refspec = "+" + ref + ":" + ref
+	case !isCommitRef(ref):
+		refspec = "+" + branchRefPrefix + ref + ":refs/remotes/origin/" + ref
+	}
+	args := []string{"-C", repoPath, "fetch"}
+	if isShallowRepository(repoPath) {
+		if depth > 0 {
+			args = append(args, fmt.Sprintf("--depth=%d", depth), "--no-tags")
+		}
+	}
+	if partialFetch && g.isPartialClone(repoPath) {
+		args = append(args, "--filter=blob:none")
+	}
+	fetchCmd := command("git", append(args, "origin", refspec)...)
+	if err := runCommand(fetchCmd); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", ref, err)
+	}
+	return nil
+}
+
+func (execGit) show(repoPath, ref, filePath string) (string, error) {
+	cmd := command("git", "-C", repoPath, "show", fmt.Sprintf("%s:%s", ref, filePath))
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+func (execGit) listTree(repoPath, ref string) ([]string, error) {
+	cmd := command("git", "-C", repoPath, "ls-tree", "-r", "--name-only", ref)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+}
+
+func (execGit) hasRef(repoPath, ref string) bool {
+	cmd := command("git", "-C", repoPath, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	return cmd.Run() == nil
+}
+
+func (execGit) hasFile(repoPath, ref, filePath string) bool {
+	cmd := command("git", "-C", repoPath, "cat-file", "-e", fmt.Sprintf("%s:%s", ref, filePath))
+	return cmd.Run() == nil
+}
+
+func (execGit) revParse(repoPath, rev string) (string, error) {
+	return gitOutput(repoPath, "rev-parse", "--verify", "--quiet", rev)
+}
+
+func (execGit) currentBranch(repoPath string) (string, error) {
+	return gitOutput(repoPath, "rev-parse", "--abbrev-ref", "HEAD")
+}
+
+func (execGit) hashObject(repoPath, filePath string) (string, error) {
+	return gitOutput(repoPath, "hash-object", "--", filePath)
+}
+
+func (execGit) unmodified(repoPath string, files []string) bool {
+	args := append([]string{"-C", repoPath, "diff", "--quiet", "HEAD", "--"}, files...)
+	return command("git", args...).Run() == nil
+}
+
+func (execGit) isShallow(repoPath string) bool {
+	output, err := gitOutput(repoPath, "rev-parse", "--is-shallow-repository")
+	return err == nil && output == "true"
+}
+
+func (execGit) originURL(repoPath string) (string, error) {
+	return gitOutput(repoPath, "remote", "get-url", "origin")
+}
+
+func (execGit) defaultBranch(repoPath string) (string, error) {
+	if output, err := gitOutput(repoPath, "symbolic-ref", "--quiet", "refs/remotes/origin/HEAD"); err == nil {
+		return strings.TrimPrefix(output, "refs/remotes/origin/"), nil
+	}
+
+	var output []byte
+	err := withRetries("Querying origin HEAD", func() (err error) {
+		output, err = commandOutput(command("git", "-C", repoPath, "ls-remote", "--symref", "origin", "HEAD"))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to query origin HEAD: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if target, ok := strings.CutPrefix(line, "ref: "); ok {
+			ref, _, _ := strings.Cut(target, "\t")
+			return strings.TrimPrefix(ref, branchRefPrefix), nil
+		}
+	}
+	return "", fmt.Errorf("origin does not advertise a default branch")
+}
+
+func (execGit) hasRemoteTag(repoPath, tag string) bool {
+	cmd := command("git", "-C", repoPath, "ls-remote", "--exit-code", "--tags", "origin", tagRefPrefix+tag)
+	return cmd.Run() == nil
+}
+
+// isPartialClone reports whether the workspace was cloned with a filter, so
+// a filtered fetch does not change its configuration. Older git versions
+// record the promisor remote in extensions.partialClone.
+func (execGit) isPartialClone(repoPath string) bool {
+	if output, err := gitOutput(repoPath, "config", "--bool", "--get", "remote.origin.promisor"); err == nil && output == "true" {
+		return true
+	}
+	output, err := gitOutput(repoPath, "config", "--get", "extensions.partialClone")
+	return err == nil && output == "origin"
+}
+
+func (execGit) openFile(repoPath, ref, filePath string) (io.ReadCloser, error) {
+	cmd := command("git", "-C", repoPath, "cat-file", "blob", fmt.Sprintf("%s:%s", ref, filePath))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (execGit) fileSize(repoPath, ref, filePath string) (int64, error) {
+	output, err := gitOutput(repoPath, "cat-file", "-s", fmt.Sprintf("%s:%s", ref, filePath))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(output, 10, 64)
+}
+
+func (execGit) renamedTo(repoPath, ref, filePath string) (string, bool) {
+	output, err := command("git", "-C", repoPath, "diff", "-M", "--name-status", ref).Output()
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 3 && strings.HasPrefix(fields[0], "R") && fields[1] == filePath {
+			return fields[2], true
+		}
+	}
+	return "", false
+}
+
+// commandReader reads the output of a running command. Close waits for the
+// command and reports its failure.
+type commandReader struct {
+	io.ReadCloser
+	cmd *subprocess
+}
+
+func (r commandReader) Close() error {
+	// Drain the output, so the command does not block writing it.
+	io.Copy(io.Discard, r.ReadCloser)
+	return r.cmd.Wait()
+}
+
+// gitOutput runs a git command in the repository and returns its trimmed
+// output.
+func gitOutput(repoPath string, arg ...string) (string, error) {
+	output, err := command("git", append([]string{"-C", repoPath}, arg...)...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// configureGitConfig points GIT_CONFIG_GLOBAL at a temporary config for the
+// plugin's own git commands, so the settings the plugin needs never modify
+// the user's global config. The temporary config includes the global config,
+// so its settings still apply. The returned function removes it again.
+func configureGitConfig() (func(), error) {
+	global := os.Getenv("GIT_CONFIG_GLOBAL")
+	if global == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			global = filepath.Join(home, ".gitconfig")
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "trusted-gitconfig-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp.Close()
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	if global != "" {
+		// Missing include files are ignored by git.
+		if err := command("git", "config", "--file", tmp.Name(), "--add", "include.path", global).Run(); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to write git config include.path: %w", err)
+		}
+	}
+	if err := os.Setenv("GIT_CONFIG_GLOBAL", tmp.Name()); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to configure GIT_CONFIG_GLOBAL: %w", err)
+	}
+	return cleanup, nil
+}
+
+// setGitConfig adds a setting to the temporary global config of the
+// plugin's git commands. The go-git backend runs none, so nothing is set.
+func setGitConfig(key, value string) error {
+	if !usesGitBinary() {
+		return nil
+	}
+	if err := runCommand(command("git", "config", "--global", key, value)); err != nil {
+		return fmt.Errorf("failed to set git config %s: %w", key, err)
+	}
+	return nil
+}
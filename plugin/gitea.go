@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// giteaContents reads trusted files through the contents API of a Gitea or
+// Forgejo instance.
+type giteaContents struct {
+	// baseURL is the API root, e.g. https://codeberg.org/api/v1.
+	baseURL string
+	// repo is the owner/name of the repository.
+	repo  string
+	token string
+}
+
+// newGiteaContents returns a reader for the Gitea instance hosting origin.
+func newGiteaContents(remote originRemote, token string) giteaContents {
+	return giteaContents{baseURL: "https://" + remote.Host + "/api/v1", repo: remote.Path, token: token}
+}
+
+func (g giteaContents) commit(ref string) (string, error) {
+	query := url.Values{"sha": {ref}, "limit": {"1"}, "stat": {"false"}, "verification": {"false"}, "files": {"false"}}
+	var commits []struct {
+		SHA string `json:"sha"`
+	}
+	if _, err := g.get(fmt.Sprintf("/repos/%s/commits?%s", g.repo, query.Encode()), &commits); err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("no commit found for %s", ref)
+	}
+	return commits[0].SHA, nil
+}
+
+func (g giteaContents) readFile(commit, filePath string) (string, bool, error) {
+	var file struct {
+		Type     string `json:"type"`
+		Encoding string `json:"encoding"`
+		Content  string `json:"content"`
+	}
+	status, err := g.get(fmt.Sprintf("/repos/%s/contents/%s?ref=%s", g.repo, escapePath(filePath), commit), &file)
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if file.Type != "file" || file.Encoding != "base64" {
+		return "", false, fmt.Errorf("%s is a %s, not a file", filePath, file.Type)
+	}
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	return string(content), true, nil
+}
+
+// get fetches an API path and decodes the JSON response into out. It returns
+// the response status code so callers can treat 404 as an answer.
+func (g giteaContents) get(path string, out interface{}) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, g.baseURL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	if g.token != "" {
+		req.Header.Set("Authorization", "token "+g.token)
+	}
+	// Errors name the endpoint without the query.
+	endpoint, _, _ := strings.Cut(path, "?")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("GET %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode %s: %w", endpoint, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,279 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// githubAPI is the base URL of the GitHub REST API. It is changed for GitHub
+// Enterprise Server by configureGitHubAPI.
+var githubAPI = "https://api.github.com"
+
+// configureGitHubAPI points the GitHub API at apiURL or, without it, at the
+// API of the GitHub host: api.github.com, or /api/v3 on an Enterprise Server.
+func configureGitHubAPI(host, apiURL string) {
+	switch {
+	case apiURL != "":
+		githubAPI = strings.TrimSuffix(apiURL, "/")
+	case host != "github.com":
+		githubAPI = "https://" + host + "/api/v3"
+	}
+}
+
+// githubRepo returns the owner/name of the repository being built.
+func githubRepo() (string, error) {
+	repo := os.Getenv("DRONE_REPO")
+	if repo == "" {
+		return "", fmt.Errorf("DRONE_REPO is required to use the GitHub API")
+	}
+	return repo, nil
+}
+
+// githubGet fetches an API path and decodes the JSON response into out. It
+// returns the response status code so callers can treat 404 as an answer.
+func githubGet(path, token string, out interface{}) (int, error) {
+	resp, err := githubDo(http.MethodGet, path, token, "application/vnd.github+json", nil)
+	if err != nil {
+		if resp != nil {
+			return resp.StatusCode, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// githubList fetches every page of a list API path, following the next links
+// of the Link header.
+func githubList[T any](path, token string) ([]T, error) {
+	var all []T
+	for path != "" {
+		resp, err := githubDo(http.MethodGet, path, token, "application/vnd.github+json", nil)
+		if err != nil {
+			return nil, err
+		}
+		var page []T
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		all = append(all, page...)
+
+		path = ""
+		if next := nextLink(resp.Header.Get("Link")); next != "" {
+			// The token is only ever sent to the API itself.
+			rest, ok := strings.CutPrefix(next, githubAPI)
+			if !ok {
+				return nil, fmt.Errorf("unexpected next page %s", next)
+			}
+			path = rest
+		}
+	}
+	return all, nil
+}
+
+// nextLink returns the URL of the next page in a Link header, e.g.
+// <https://api.github.com/...&page=2>; rel="next".
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// githubGetRaw fetches an API path with a custom media type, such as the raw
+// content of a file, and returns the response body.
+func githubGetRaw(path, token, accept string) ([]byte, int, error) {
+	resp, err := githubDo(http.MethodGet, path, token, accept, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, resp.StatusCode, err
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// githubPost sends in as JSON to an API path and decodes the JSON response
+// into out.
+func githubPost(path, token string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := githubDo(http.MethodPost, path, token, "application/vnd.github+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// githubDo sends a request. Unless it returns a nil error, the response body
+// is already closed.
+func githubDo(method, path, token, accept string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, githubAPI+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return resp, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// githubContents reads trusted files through the GitHub Contents API.
+type githubContents struct {
+	repo  string
+	token string
+}
+
+func (g githubContents) commit(ref string) (string, error) {
+	body, _, err := githubGetRaw(fmt.Sprintf("/repos/%s/commits/%s", g.repo, url.PathEscape(ref)), g.token, "application/vnd.github.sha")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (g githubContents) readFile(commit, filePath string) (string, bool, error) {
+	path := fmt.Sprintf("/repos/%s/contents/%s?ref=%s", g.repo, escapePath(filePath), commit)
+	body, status, err := githubGetRaw(path, g.token, "application/vnd.github.raw+json")
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(body), true, nil
+}
+
+// escapePath escapes every segment of a slash separated path for use in a URL.
+func escapePath(filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// githubReview is a pull request review.
+type githubReview struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	State    string `json:"state"`
+	CommitID string `json:"commit_id"`
+}
+
+// githubApprovals returns the logins whose latest review of a pull request
+// is an approval of its head or of verified, the commit being built. An
+// approval of an earlier commit does not cover content pushed after it.
+func githubApprovals(repo string, number int, verified, token string) ([]string, error) {
+	var pull struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	}
+	if _, err := githubGet(fmt.Sprintf("/repos/%s/pulls/%d", repo, number), token, &pull); err != nil {
+		return nil, fmt.Errorf("failed to look up pull request #%d: %w", number, err)
+	}
+	reviews, err := githubList[githubReview](fmt.Sprintf("/repos/%s/pulls/%d/reviews?per_page=100", repo, number), token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reviews of pull request #%d: %w", number, err)
+	}
+	// Reviews are returned in chronological order, so later states win.
+	latest := make(map[string]string)
+	var order []string
+	for _, review := range reviews {
+		if review.State == "COMMENTED" {
+			continue
+		}
+		if _, seen := latest[review.User.Login]; !seen {
+			order = append(order, review.User.Login)
+		}
+		state := review.State
+		if state == "APPROVED" && review.CommitID != pull.Head.SHA && review.CommitID != verified {
+			state = "STALE"
+		}
+		latest[review.User.Login] = state
+	}
+	var approvers []string
+	for _, login := range order {
+		if latest[login] == "APPROVED" {
+			approvers = append(approvers, login)
+		}
+	}
+	return approvers, nil
+}
+
+// githubCommitPulls returns the numbers of the pull requests containing a commit.
+func githubCommitPulls(repo, commit, token string) ([]int, error) {
+	var pulls []struct {
+		Number int `json:"number"`
+	}
+	if _, err := githubGet(fmt.Sprintf("/repos/%s/commits/%s/pulls", repo, commit), token, &pulls); err != nil {
+		return nil, fmt.Errorf("failed to look up pull requests of commit %s: %w", commit, err)
+	}
+	numbers := make([]int, len(pulls))
+	for i, pull := range pulls {
+		numbers[i] = pull.Number
+	}
+	return numbers, nil
+}
+
+// githubTeamMember reports whether login is an active member of org/team.
+func githubTeamMember(org, team, login, token string) (bool, error) {
+	var membership struct {
+		State string `json:"state"`
+	}
+	status, err := githubGet(fmt.Sprintf("/orgs/%s/teams/%s/memberships/%s", org, team, login), token, &membership)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up membership of %s in team %s/%s: %w", login, org, team, err)
+	}
+	return membership.State == "active", nil
+}
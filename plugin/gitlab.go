@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// gitlabJobTokenUser is the username git sends a CI job token with.
+const gitlabJobTokenUser = "gitlab-ci-token"
+
+// gitlabClient calls the REST API of a GitLab instance.
+type gitlabClient struct {
+	// baseURL is the API root, e.g. https://gitlab.com/api/v4.
+	baseURL string
+	token   string
+	// jobToken sends token as a CI job token instead of a personal,
+	// project or group access token.
+	jobToken bool
+}
+
+// newGitLabClient returns a client for the GitLab instance hosting origin.
+// Without a token, it falls back to the job token of a GitLab CI job.
+func newGitLabClient(remote originRemote, token string) gitlabClient {
+	client := gitlabClient{baseURL: "https://" + remote.Host + "/api/v4", token: token}
+	if token == "" && os.Getenv("CI_JOB_TOKEN") != "" {
+		client.token, client.jobToken = os.Getenv("CI_JOB_TOKEN"), true
+	}
+	return client
+}
+
+// gitlabProject returns the URL-encoded project ID for a repository path.
+func gitlabProject(path string) string {
+	return url.PathEscape(path)
+}
+
+// get fetches an API path and decodes the JSON response into out. It returns
+// the response status code so callers can treat 404 as an answer.
+func (c gitlabClient) get(path string, out interface{}) (int, error) {
+	resp, err := c.do(path)
+	if err != nil {
+		if resp != nil {
+			return resp.StatusCode, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// getRaw fetches an API path and returns the response body.
+func (c gitlabClient) getRaw(path string) ([]byte, int, error) {
+	resp, err := c.do(path)
+	if err != nil {
+		if resp != nil {
+			return nil, resp.StatusCode, err
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// do sends a GET request. Unless it returns a nil error, the response body
+// is already closed.
+func (c gitlabClient) do(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.token == "":
+	case c.jobToken:
+		req.Header.Set("JOB-TOKEN", c.token)
+	default:
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return resp, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// gitlabFiles reads trusted files through the GitLab Repository Files API.
+type gitlabFiles struct {
+	client  gitlabClient
+	project string
+}
+
+func (g gitlabFiles) commit(ref string) (string, error) {
+	var commit struct {
+		ID string `json:"id"`
+	}
+	if _, err := g.client.get(fmt.Sprintf("/projects/%s/repository/commits/%s", gitlabProject(g.project), url.PathEscape(ref)), &commit); err != nil {
+		return "", err
+	}
+	return commit.ID, nil
+}
+
+func (g gitlabFiles) readFile(commit, filePath string) (string, bool, error) {
+	path := fmt.Sprintf("/projects/%s/repository/files/%s/raw?ref=%s", gitlabProject(g.project), url.PathEscape(filePath), commit)
+	body, status, err := g.client.getRaw(path)
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(body), true, nil
+}
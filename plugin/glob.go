@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// isGlob reports whether the path contains glob meta characters.
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// matchGlob matches a slash separated path against a pattern. Each segment is
+// matched with path.Match, and a "**" segment matches zero or more segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// listFilesInBranch lists every file tracked on the given branch.
+func listFilesInBranch(repoPath, branch string) ([]string, error) {
+	return gitOps.listTree(repoPath, branch)
+}
+
+// listTrustedFiles lists the files on the trusted branch, fetching it from
+// origin when it is not available locally.
+func listTrustedFiles(repoPath, branch string) ([]string, error) {
+	files, err := listFilesInBranch(repoPath, branch)
+	if err == nil {
+		return files, nil
+	}
+	if err := fetchRef(repoPath, branch); err != nil {
+		return nil, err
+	}
+	files, err = listFilesInBranch(repoPath, fetchedRef(branch))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files on trusted branch %s: %w", branch, err)
+	}
+	return files, nil
+}
+
+// expandFilePaths replaces glob patterns with the matching files from the
+// trusted branch tree, skipping excluded files. Plain paths are passed
+// through untouched.
+func expandFilePaths(repoPath, branch string, filePaths []string, exclude excluder) ([]string, error) {
+	var trustedFiles []string
+	var expanded []string
+	for _, p := range filePaths {
+		if !isGlob(p) {
+			expanded = appendUnique(expanded, p)
+			continue
+		}
+
+		if trustedFiles == nil {
+			files, err := listTrustedFiles(repoPath, branch)
+			if err != nil {
+				return nil, err
+			}
+			trustedFiles = files
+		}
+
+		matched := 0
+		for _, f := range trustedFiles {
+			if !matchGlob(p, f) {
+				continue
+			}
+			matched++
+			if exclude.excluded(f) {
+				continue
+			}
+			expanded = appendUnique(expanded, f)
+		}
+		if matched == 0 {
+			return nil, fmt.Errorf("pattern %s matched no files on trusted branch %s", p, branch)
+		}
+	}
+	return expanded, nil
+}
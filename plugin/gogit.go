@@ -0,0 +1,359 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// goGitCommitRef briefly holds a commit fetched by its SHA, since go-git
+// needs a destination for every refspec.
+const goGitCommitRef = "refs/drone-read-trusted/commit"
+
+// goGit reads the repository with go-git. Its HTTP requests go through the
+// same transport as the API clients, so the proxy and TLS settings apply.
+type goGit struct{}
+
+// fetch updates the same refs as the git binary: the remote-tracking branch,
+// or the tag itself. A complete clone is never made shallow.
+func (goGit) fetch(repoPath, ref string, depth int) error {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", ref, err)
+	}
+	var refspec config.RefSpec
+	switch {
+	case isTagRef(ref):
+		refspec = config.RefSpec("+" + ref + ":" + ref)
+	case isCommitRef(ref):
+		refspec = config.RefSpec(ref + ":" + goGitCommitRef)
+		defer repo.Storer.RemoveReference(goGitCommitRef)
+	default:
+		refspec = config.RefSpec("+" + branchRefPrefix + ref + ":refs/remotes/origin/" + ref)
+	}
+	auth, err := goGitAuth(repo)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", ref, err)
+	}
+	if shallow, err := repo.Storer.Shallow(); err != nil || len(shallow) == 0 {
+		depth = 0
+	}
+	ctx, cancel := gitContext()
+	defer cancel()
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{refspec},
+		Depth:      depth,
+		Auth:       auth,
+		Tags:       git.NoTags,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		if exceededGitTimeout(ctx) {
+			return fmt.Errorf("failed to fetch %s: %w", ref, gitTimeoutError("go-git fetch", ctx.Err()))
+		}
+		return fmt.Errorf("failed to fetch %s: %w", ref, errors.New(Redact(err.Error())))
+	}
+	return nil
+}
+
+func (goGit) show(repoPath, ref, filePath string) (string, error) {
+	tree, err := goGitTree(repoPath, ref)
+	if err != nil {
+		return "", err
+	}
+	file, err := tree.File(filePath)
+	if err != nil {
+		return "", err
+	}
+	return file.Contents()
+}
+
+func (goGit) listTree(repoPath, ref string) ([]string, error) {
+	tree, err := goGitTree(repoPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = tree.Files().ForEach(func(file *object.File) error {
+		files = append(files, file.Name)
+		return nil
+	})
+	return files, err
+}
+
+func (goGit) hasRef(repoPath, ref string) bool {
+	_, err := goGitTree(repoPath, ref)
+	return err == nil
+}
+
+func (goGit) hasFile(repoPath, ref, filePath string) bool {
+	tree, err := goGitTree(repoPath, ref)
+	if err != nil {
+		return false
+	}
+	_, err = tree.File(filePath)
+	return err == nil
+}
+
+func (goGit) revParse(repoPath, rev string) (string, error) {
+	if base, filePath, ok := strings.Cut(rev, ":"); ok {
+		tree, err := goGitTree(repoPath, base)
+		if err != nil {
+			return "", err
+		}
+		entry, err := tree.FindEntry(filePath)
+		if err != nil {
+			return "", err
+		}
+		return entry.Hash.String(), nil
+	}
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return "", err
+	}
+	// Revisions resolve to commits, with annotated tags peeled.
+	hash, err := repo.ResolveRevision(plumbing.Revision(strings.TrimSuffix(rev, "^{commit}")))
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (goGit) currentBranch(repoPath string) (string, error) {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	if !head.Name().IsBranch() {
+		return "HEAD", nil
+	}
+	return head.Name().Short(), nil
+}
+
+// hashObject hashes the file as is, without the clean filters, such as
+// core.autocrlf, that git applies.
+func (goGit) hashObject(repoPath, filePath string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(repoPath, filePath))
+	if err != nil {
+		return "", err
+	}
+	return plumbing.ComputeHash(plumbing.BlobObject, content).String(), nil
+}
+
+func (g goGit) unmodified(repoPath string, files []string) bool {
+	for _, filePath := range files {
+		current, err := g.hashObject(repoPath, filePath)
+		if err != nil {
+			return false
+		}
+		committed, err := g.revParse(repoPath, "HEAD:"+filePath)
+		if err != nil || committed != current {
+			return false
+		}
+	}
+	return true
+}
+
+func (goGit) isShallow(repoPath string) bool {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return false
+	}
+	shallow, err := repo.Storer.Shallow()
+	return err == nil && len(shallow) > 0
+}
+
+func (goGit) originURL(repoPath string) (string, error) {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return "", fmt.Errorf("origin has no URL")
+	}
+	return urls[0], nil
+}
+
+func (goGit) defaultBranch(repoPath string) (string, error) {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if ref, err := repo.Reference("refs/remotes/origin/HEAD", false); err == nil && ref.Type() == plumbing.SymbolicReference {
+		return strings.TrimPrefix(ref.Target().String(), "refs/remotes/origin/"), nil
+	}
+	var refs []*plumbing.Reference
+	err = withRetries("Querying origin HEAD", func() (err error) {
+		refs, err = listGoGitRemote(repo)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to query origin HEAD: %w", err)
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target().Short(), nil
+		}
+	}
+	return "", fmt.Errorf("origin does not advertise a default branch")
+}
+
+func (goGit) hasRemoteTag(repoPath, tag string) bool {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return false
+	}
+	refs, err := listGoGitRemote(repo)
+	if err != nil {
+		return false
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.NewTagReferenceName(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (goGit) openFile(repoPath, ref, filePath string) (io.ReadCloser, error) {
+	tree, err := goGitTree(repoPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	file, err := tree.File(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return file.Reader()
+}
+
+func (goGit) fileSize(repoPath, ref, filePath string) (int64, error) {
+	tree, err := goGitTree(repoPath, ref)
+	if err != nil {
+		return 0, err
+	}
+	file, err := tree.File(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return file.Size, nil
+}
+
+// renamedTo compares the tree of ref with HEAD, so unlike the git binary it
+// does not see renames that were not committed.
+func (goGit) renamedTo(repoPath, ref, filePath string) (string, bool) {
+	from, err := goGitTree(repoPath, ref)
+	if err != nil {
+		return "", false
+	}
+	to, err := goGitTree(repoPath, "HEAD")
+	if err != nil {
+		return "", false
+	}
+	// git detects renames of files that are at least half the same.
+	options := *object.DefaultDiffTreeOptions
+	options.RenameScore = 50
+	changes, err := object.DiffTreeWithOptions(commandCtx, from, to, &options)
+	if err != nil {
+		return "", false
+	}
+	for _, change := range changes {
+		if change.From.Name == filePath && change.To.Name != "" && change.To.Name != filePath {
+			return change.To.Name, true
+		}
+	}
+	return "", false
+}
+
+// listGoGitRemote lists the refs of origin, like git ls-remote.
+func listGoGitRemote(repo *git.Repository) ([]*plumbing.Reference, error) {
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+	auth, err := goGitAuth(repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := gitContext()
+	defer cancel()
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		if exceededGitTimeout(ctx) {
+			return nil, gitTimeoutError("go-git ls-remote", ctx.Err())
+		}
+		return nil, errors.New(Redact(err.Error()))
+	}
+	return refs, nil
+}
+
+// openGoGit opens the repository containing repoPath, which may be a linked
+// worktree.
+func openGoGit(repoPath string) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(repoPath, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+}
+
+// goGitTree returns the tree of the commit ref resolves to. Annotated tags
+// are peeled to their commit.
+func goGitTree(repoPath, ref string) (*object.Tree, error) {
+	repo, err := openGoGit(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	return commit.Tree()
+}
+
+// goGitAuth returns the credentials for the host of origin, the same ones the
+// git binary receives through GIT_ASKPASS. SSH remotes use the SSH agent.
+func goGitAuth(repo *git.Repository) (transport.AuthMethod, error) {
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("origin has no URL")
+	}
+	endpoint, err := transport.NewEndpoint(urls[0])
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Protocol != "http" && endpoint.Protocol != "https" {
+		return nil, nil
+	}
+	entries, err := askpassCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := findCredential(entries, endpoint.Host); ok {
+		return &githttp.BasicAuth{Username: entry.Username, Password: entry.Token}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gpgKeyring is a temporary GnuPG home holding only the configured public
+// keys, so signatures by any other key fail to verify.
+type gpgKeyring struct {
+	home string
+}
+
+// newGPGKeyring imports the ASCII armored public keys into a new keyring.
+func newGPGKeyring(publicKeys string) (*gpgKeyring, error) {
+	home, err := os.MkdirTemp("", "trusted-gnupg-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GnuPG home: %w", err)
+	}
+	k := &gpgKeyring{home: home}
+
+	var stderr bytes.Buffer
+	cmd := command("gpg", "--batch", "--quiet", "--import")
+	k.setEnv(cmd)
+	cmd.Stdin = strings.NewReader(publicKeys)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		k.Close()
+		return nil, fmt.Errorf("failed to import GPG public keys: %w", commandError(err, stderr.String()))
+	}
+	return k, nil
+}
+
+// setEnv makes a command use the keyring, keeping the rest of its
+// environment.
+func (k *gpgKeyring) setEnv(cmd *subprocess) {
+	cmd.Env = append(cmd.Environ(), "GNUPGHOME="+k.home)
+}
+
+// Close removes the keyring.
+func (k *gpgKeyring) Close() error {
+	return os.RemoveAll(k.home)
+}
+
+// verifyDetached verifies a detached signature over content.
+func (k *gpgKeyring) verifyDetached(content, signature string) error {
+	sig, err := os.CreateTemp("", "trusted-*.asc")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(sig.Name())
+	if _, err := sig.WriteString(signature); err != nil {
+		sig.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := sig.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := command("gpg", "--batch", "--verify", sig.Name(), "-")
+	k.setEnv(cmd)
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return commandError(err, stderr.String())
+	}
+	return nil
+}
+
+// gpgSignaturePolicy requires a detached signature <file>.asc next to each
+// file on the trusted ref, made by a key in the keyring.
+type gpgSignaturePolicy struct {
+	keyring *gpgKeyring
+}
+
+func (p gpgSignaturePolicy) check(repoPath, ref, filePath string) (string, error) {
+	signature, found, err := readOptionalTrustedFile(repoPath, ref, filePath+".asc")
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return fmt.Sprintf("no detached signature %s.asc on the trusted branch", filePath), nil
+	}
+	content, err := readTrustedFile(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	if err := p.keyring.verifyDetached(content, signature); err != nil {
+		logrus.Warnf("Signature verification of %s failed: %v", filePath, err)
+		return fmt.Sprintf("detached signature %s.asc is not valid for a trusted key", filePath), nil
+	}
+	logrus.Infof("File %s has a valid detached GPG signature", filePath)
+	return "", nil
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// harnessCode reads trusted files through the REST API of Harness Code.
+type harnessCode struct {
+	// baseURL is the API root, e.g. https://app.harness.io/code/api/v1.
+	baseURL string
+	// repoRef is the account/org/project/repo path of the repository.
+	repoRef string
+	token   string
+}
+
+// newHarnessCode returns a reader for the Harness Code repository in origin,
+// whose path is account/org/project/repo. Harness SaaS serves git from
+// git.harness.io and the API from app.harness.io.
+func newHarnessCode(remote originRemote, token string) (harnessCode, error) {
+	if strings.Count(remote.Path, "/") < 1 {
+		return harnessCode{}, fmt.Errorf("origin is not a Harness Code remote")
+	}
+	host := remote.Host
+	if host == "git.harness.io" {
+		host = "app.harness.io"
+	}
+	return harnessCode{baseURL: "https://" + host + "/code/api/v1", repoRef: remote.Path, token: token}, nil
+}
+
+func (h harnessCode) commit(ref string) (string, error) {
+	body, _, err := h.get("/commits", url.Values{"git_ref": {ref}, "limit": {"1"}})
+	if err != nil {
+		return "", err
+	}
+	var list struct {
+		Commits []struct {
+			SHA string `json:"sha"`
+		} `json:"commits"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return "", fmt.Errorf("failed to decode the commits of %s: %w", ref, err)
+	}
+	if len(list.Commits) == 0 {
+		return "", fmt.Errorf("no commit found for %s", ref)
+	}
+	return list.Commits[0].SHA, nil
+}
+
+func (h harnessCode) readFile(commit, filePath string) (string, bool, error) {
+	body, status, err := h.get("/raw/"+escapePath(filePath), url.Values{"git_ref": {commit}})
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(body), true, nil
+}
+
+// get fetches a repository API path, authenticating with the API key, and
+// returns the response body and status code.
+func (h harnessCode) get(path string, query url.Values) ([]byte, int, error) {
+	// The account routes requests through the Harness gateway.
+	account, _, _ := strings.Cut(h.repoRef, "/")
+	query.Set("accountIdentifier", account)
+	endpoint := fmt.Sprintf("/repos/%s/+%s", url.PathEscape(h.repoRef), path)
+	req, err := http.NewRequest(http.MethodGet, h.baseURL+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if h.token != "" {
+		req.Header.Set("x-api-key", h.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("GET %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read %s: %w", endpoint, err)
+	}
+	return body, resp.StatusCode, nil
+}
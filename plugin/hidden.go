@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hiddenRunes are invisible or bidirectional control characters used in
+// "trojan source" attacks to make code read differently than it executes.
+var hiddenRunes = map[rune]string{
+	'\u061C': "ARABIC LETTER MARK",
+	'\u200B': "ZERO WIDTH SPACE",
+	'\u200C': "ZERO WIDTH NON-JOINER",
+	'\u200D': "ZERO WIDTH JOINER",
+	'\u200E': "LEFT-TO-RIGHT MARK",
+	'\u200F': "RIGHT-TO-LEFT MARK",
+	'\u202A': "LEFT-TO-RIGHT EMBEDDING",
+	'\u202B': "RIGHT-TO-LEFT EMBEDDING",
+	'\u202C': "POP DIRECTIONAL FORMATTING",
+	'\u202D': "LEFT-TO-RIGHT OVERRIDE",
+	'\u202E': "RIGHT-TO-LEFT OVERRIDE",
+	'\u2060': "WORD JOINER",
+	'\u2066': "LEFT-TO-RIGHT ISOLATE",
+	'\u2067': "RIGHT-TO-LEFT ISOLATE",
+	'\u2068': "FIRST STRONG ISOLATE",
+	'\u2069': "POP DIRECTIONAL ISOLATE",
+	'\uFEFF': "ZERO WIDTH NO-BREAK SPACE",
+}
+
+// hiddenChar is an occurrence of a hidden character.
+type hiddenChar struct {
+	Rune rune
+	Line int
+}
+
+func (h hiddenChar) String() string {
+	return fmt.Sprintf("U+%04X %s on line %d", h.Rune, hiddenRunes[h.Rune], h.Line)
+}
+
+// findHiddenChars returns the hidden characters in content, skipping a
+// leading byte order mark.
+func findHiddenChars(content string) []hiddenChar {
+	var found []hiddenChar
+	content = strings.TrimPrefix(content, utf8BOM)
+	line := 1
+	for _, r := range content {
+		if r == '\n' {
+			line++
+			continue
+		}
+		if _, ok := hiddenRunes[r]; ok {
+			found = append(found, hiddenChar{Rune: r, Line: line})
+		}
+	}
+	return found
+}
+
+// addedHiddenChars returns the hidden characters of the current content that
+// are not accounted for by the same characters in the trusted content.
+func addedHiddenChars(trusted, current string) []hiddenChar {
+	trustedCounts := make(map[rune]int)
+	for _, h := range findHiddenChars(trusted) {
+		trustedCounts[h.Rune]++
+	}
+	var added []hiddenChar
+	for _, h := range findHiddenChars(current) {
+		if trustedCounts[h.Rune] > 0 {
+			trustedCounts[h.Rune]--
+			continue
+		}
+		added = append(added, h)
+	}
+	return added
+}
+
+// hiddenCharsReason describes added hidden characters for the results.
+func hiddenCharsReason(added []hiddenChar) string {
+	reason := fmt.Sprintf("hidden unicode characters added on current branch: %s", added[0])
+	if len(added) > 1 {
+		reason += fmt.Sprintf(" and %d more", len(added)-1)
+	}
+	return reason
+}
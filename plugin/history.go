@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// historyLimit bounds how far back the history of the trusted branch is
+// searched when historical versions are allowed.
+type historyLimit struct {
+	enabled bool
+	depth   int
+	since   string
+}
+
+// matchHistory compares the current file against every earlier version of it
+// on the trusted ref, newest first, and returns the commit of the first
+// version that matches.
+func matchHistory(plan *verificationPlan, ref, filePath string, opts compareOptions, current currentFile) (string, string, error) {
+	resolved, err := resolveTrustedRef(plan.repoPath, ref, filePath)
+	if err != nil {
+		return "", "", err
+	}
+	commits, err := fileHistory(plan.repoPath, resolved, filePath, plan.history)
+	if err != nil {
+		return "", "", err
+	}
+	if len(commits) == plan.history.depth && isShallowRepository(plan.repoPath) {
+		logrus.Warnf("Repository is a shallow clone, the history of %s may be incomplete", filePath)
+	}
+
+	seen := make(map[string]bool)
+	for _, commit := range commits {
+		// Skip commits that deleted the file and versions already compared.
+		oid, err := revParse(plan.repoPath, fmt.Sprintf("%s:%s", commit, filePath))
+		if err != nil || seen[oid] {
+			continue
+		}
+		seen[oid] = true
+
+		matched, content, err := compareWithSource(plan.repoPath, trustedSource{Ref: commit, Path: filePath}, filePath, opts, current)
+		if err != nil {
+			return "", "", err
+		}
+		if matched {
+			return commit, content, nil
+		}
+	}
+	return "", "", nil
+}
+
+// fileHistory lists the commits on ref that touched filePath, newest first.
+func fileHistory(repoPath, ref, filePath string, limit historyLimit) ([]string, error) {
+	args := []string{"-C", repoPath, "log", "--format=%H", fmt.Sprintf("--max-count=%d", limit.depth)}
+	if limit.since != "" {
+		args = append(args, "--since="+limit.since)
+	}
+	args = append(args, ref, "--", filePath)
+	output, err := command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the history of %s on %s: %w", filePath, ref, err)
+	}
+	return strings.Fields(string(output)), nil
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseExpectedHMACs maps files to their expected HMAC-SHA256 values, in the
+// same formats as expected_sha256.
+func parseExpectedHMACs(expected string, filePaths []string) (map[string]string, []string, error) {
+	return parseExpectedValues("expected_hmac", "HMAC-SHA256", expected, filePaths)
+}
+
+// verifyExpectedHMACs compares the HMAC-SHA256 of workspace files, keyed with
+// the pipeline secret, against the expected values. The computed HMAC is never
+// logged, so a mismatch does not reveal the value a modified file would need.
+func verifyExpectedHMACs(repoPath, secret string, macs map[string]string, order []string) ([]fileResult, error) {
+	var results []fileResult
+	for _, filePath := range order {
+		fullPath, err := resolveInRepo(repoPath, filePath)
+		if err != nil {
+			return nil, err
+		}
+		mac, digest, err := hmacFile(fullPath, secret)
+		if errors.Is(err, fs.ErrNotExist) {
+			logrus.Warnf("File %s does not exist on the current branch", filePath)
+			results = append(results, fileResult{Path: filePath, Reason: reasonDeleted})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", fullPath, err)
+		}
+
+		expected, _ := hex.DecodeString(macs[filePath])
+		result := fileResult{Path: filePath, SHA256: digest, Matched: hmac.Equal(mac, expected)}
+		if result.Matched {
+			logrus.Infof("File %s matches the expected HMAC", filePath)
+		} else {
+			logrus.Warnf("File %s does not match the expected HMAC", filePath)
+			result.Reason = reasonHMACDiffers
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// hmacFile streams a file through HMAC-SHA256 and SHA-256 at once.
+func hmacFile(path, secret string) ([]byte, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	mac := hmac.New(sha256.New, []byte(secret))
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(mac, digest), f); err != nil {
+		return nil, "", err
+	}
+	return mac.Sum(nil), hex.EncodeToString(digest.Sum(nil)), nil
+}
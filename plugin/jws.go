@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+)
+
+// parseJWSPublicKey parses the PEM encoded public key that signs trust
+// manifests and expected digests.
+func parseJWSPublicKey(key string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("jws_public_key is not a PEM encoded public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jws_public_key: %w", err)
+	}
+	switch parsed.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		return parsed, nil
+	default:
+		return nil, fmt.Errorf("unsupported jws_public_key type %T", parsed)
+	}
+}
+
+// verifyJWS verifies a JWS in compact serialization and returns its payload.
+// The algorithm in the header must match the key, so a token cannot pick a
+// weaker algorithm or none.
+func verifyJWS(token string, key crypto.PublicKey) ([]byte, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWS in compact serialization")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS header encoding: %w", err)
+	}
+	var header struct {
+		Alg  string   `json:"alg"`
+		Crit []string `json:"crit"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("invalid JWS header: %w", err)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("unsupported critical JWS header parameters %v", header.Crit)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS payload encoding: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS signature encoding: %w", err)
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	if err := verifyJWSSignature(header.Alg, key, signingInput, signature); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// verifyJWSSignature checks a signature made with alg over the signing input.
+func verifyJWSSignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("JWS algorithm %q does not match the Ed25519 key", alg)
+		}
+		if !ed25519.Verify(k, signingInput, signature) {
+			return fmt.Errorf("invalid JWS signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		// The curve determines the one acceptable algorithm.
+		expected := map[int]string{256: "ES256", 384: "ES384", 521: "ES512"}[k.Curve.Params().BitSize]
+		if alg != expected {
+			return fmt.Errorf("JWS algorithm %q does not match the ECDSA key", alg)
+		}
+		h := jwsHash(alg)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid JWS signature")
+		}
+		h.Write(signingInput)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, h.Sum(nil), r, s) {
+			return fmt.Errorf("invalid JWS signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		h := jwsHash(alg)
+		if h == nil || (!strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "PS")) {
+			return fmt.Errorf("JWS algorithm %q does not match the RSA key", alg)
+		}
+		h.Write(signingInput)
+		hashID := map[int]crypto.Hash{32: crypto.SHA256, 48: crypto.SHA384, 64: crypto.SHA512}[h.Size()]
+		var err error
+		if strings.HasPrefix(alg, "PS") {
+			err = rsa.VerifyPSS(k, hashID, h.Sum(nil), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(k, hashID, h.Sum(nil), signature)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid JWS signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported JWS key type %T", key)
+	}
+}
+
+// jwsHash returns the hash of an RS, PS or ES algorithm, or nil.
+func jwsHash(alg string) hash.Hash {
+	if len(alg) != 5 {
+		return nil
+	}
+	switch alg[2:] {
+	case "256":
+		return sha256.New()
+	case "384":
+		return sha512.New384()
+	case "512":
+		return sha512.New()
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+// jwsSigner signs a JWS signing input with one algorithm.
+type jwsSigner struct {
+	alg  string
+	key  crypto.PublicKey
+	sign func(t *testing.T, input []byte) []byte
+}
+
+func ecdsaSigner(t *testing.T, alg string, curve elliptic.Curve) jwsSigner {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwsSigner{alg: alg, key: &key.PublicKey, sign: func(t *testing.T, input []byte) []byte {
+		h := jwsHash(alg)
+		h.Write(input)
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature
+	}}
+}
+
+func rsaSigner(t *testing.T, key *rsa.PrivateKey, alg string, hashID crypto.Hash) jwsSigner {
+	return jwsSigner{alg: alg, key: &key.PublicKey, sign: func(t *testing.T, input []byte) []byte {
+		h := hashID.New()
+		h.Write(input)
+		var signature []byte
+		var err error
+		if strings.HasPrefix(alg, "PS") {
+			signature, err = rsa.SignPSS(rand.Reader, key, hashID, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, hashID, h.Sum(nil))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}}
+}
+
+func ed25519Signer(t *testing.T) jwsSigner {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwsSigner{alg: "EdDSA", key: public, sign: func(t *testing.T, input []byte) []byte {
+		return ed25519.Sign(private, input)
+	}}
+}
+
+// signJWS builds a compact JWS with the given header and payload.
+func signJWS(t *testing.T, s jwsSigner, header, payload string) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return input + "." + base64.RawURLEncoding.EncodeToString(s.sign(t, []byte(input)))
+}
+
+func TestVerifyJWS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []jwsSigner{
+		ed25519Signer(t),
+		ecdsaSigner(t, "ES256", elliptic.P256()),
+		ecdsaSigner(t, "ES384", elliptic.P384()),
+		ecdsaSigner(t, "ES512", elliptic.P521()),
+		rsaSigner(t, rsaKey, "RS256", crypto.SHA256),
+		rsaSigner(t, rsaKey, "RS384", crypto.SHA384),
+		rsaSigner(t, rsaKey, "RS512", crypto.SHA512),
+		rsaSigner(t, rsaKey, "PS256", crypto.SHA256),
+		rsaSigner(t, rsaKey, "PS512", crypto.SHA512),
+	}
+	const payload = `{"files":{"build.sh":"sha256:00"}}`
+	for _, s := range signers {
+		token := signJWS(t, s, `{"alg":"`+s.alg+`"}`, payload)
+		got, err := verifyJWS(token, s.key)
+		if err != nil {
+			t.Errorf("%s: verifyJWS failed: %v", s.alg, err)
+		} else if string(got) != payload {
+			t.Errorf("%s: verifyJWS = %q, want %q", s.alg, got, payload)
+		}
+	}
+}
+
+func TestVerifyJWSRejects(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := ed25519Signer(t)
+	es256 := ecdsaSigner(t, "ES256", elliptic.P256())
+	rs256 := rsaSigner(t, rsaKey, "RS256", crypto.SHA256)
+	const payload = `{"files":{}}`
+	valid := signJWS(t, es256, `{"alg":"ES256"}`, payload)
+	parts := strings.Split(valid, ".")
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name  string
+		token string
+		key   crypto.PublicKey
+	}{
+		{name: "alg none", token: encode(`{"alg":"none"}`) + "." + parts[1] + ".", key: es256.key},
+		{name: "alg none on RSA", token: encode(`{"alg":"none"}`) + "." + parts[1] + ".", key: rs256.key},
+		{name: "missing alg", token: encode(`{}`) + "." + parts[1] + "." + parts[2], key: es256.key},
+		// The signature is valid, but the header names another algorithm.
+		{name: "wrong algorithm for the curve", token: encode(`{"alg":"ES384"}`) + "." + parts[1] + "." + parts[2], key: es256.key},
+		{name: "RSA algorithm on an ECDSA key", token: signJWS(t, es256, `{"alg":"RS256"}`, payload), key: es256.key},
+		{name: "HMAC on an RSA key", token: signJWS(t, rs256, `{"alg":"HS256"}`, payload), key: rs256.key},
+		{name: "PKCS1 signature labelled PSS", token: signJWS(t, rs256, `{"alg":"PS256"}`, payload), key: rs256.key},
+		{name: "ECDSA algorithm on an Ed25519 key", token: signJWS(t, ed, `{"alg":"ES256"}`, payload), key: ed.key},
+		{name: "Ed25519 token for an ECDSA key", token: signJWS(t, ed, `{"alg":"EdDSA"}`, payload), key: es256.key},
+		{name: "ECDSA token for an RSA key", token: valid, key: rs256.key},
+		{name: "RSA token for an Ed25519 key", token: signJWS(t, rs256, `{"alg":"RS256"}`, payload), key: ed.key},
+		{name: "other key of the same type", token: valid, key: ecdsaSigner(t, "ES256", elliptic.P256()).key},
+		{name: "tampered payload", token: parts[0] + "." + encode(`{"files":{"build.sh":"sha256:ff"}}`) + "." + parts[2], key: es256.key},
+		{name: "tampered header", token: encode(`{"alg":"ES256","kid":"x"}`) + "." + parts[1] + "." + parts[2], key: es256.key},
+		{name: "truncated signature", token: parts[0] + "." + parts[1] + "." + parts[2][:20], key: es256.key},
+		{name: "unknown critical header", token: signJWS(t, es256, `{"alg":"ES256","crit":["exp"]}`, payload), key: es256.key},
+		{name: "two parts", token: parts[0] + "." + parts[1], key: es256.key},
+		{name: "bad header encoding", token: "!." + parts[1] + "." + parts[2], key: es256.key},
+	}
+	for _, tt := range tests {
+		if got, err := verifyJWS(tt.token, tt.key); err == nil {
+			t.Errorf("%s: verifyJWS = %q, want an error", tt.name, got)
+		}
+	}
+}
+
+func TestParseJWSPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if _, err := parseJWSPublicKey(public); err != nil {
+		t.Errorf("parseJWSPublicKey failed: %v", err)
+	}
+
+	private, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, invalid := range map[string]string{
+		"not PEM":     "-----BEGIN",
+		"private key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: private})),
+		"garbage":     string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("key")})),
+	} {
+		if _, err := parseJWSPublicKey(invalid); err == nil {
+			t.Errorf("%s: parseJWSPublicKey succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"crypto"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifest lists the paths that must be verified. It is always read from the
+// trusted branch so the current branch cannot weaken it.
+type manifest struct {
+	Files []manifestEntry `yaml:"files"`
+}
+
+// manifestEntry is a single file, glob pattern or directory to verify.
+type manifestEntry struct {
+	Path           string `yaml:"path"`
+	Dir            string `yaml:"dir"`
+	compareOptions `yaml:",inline"`
+}
+
+// loadManifest reads and parses the manifest from the trusted branch. With a
+// jwsKey the manifest must be a JWS signed by that key, whose payload is the
+// YAML manifest.
+func loadManifest(repoPath, branch, manifestPath string, jwsKey crypto.PublicKey) (*manifest, error) {
+	manifestPath, err := cleanRelativePath(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest path: %w", err)
+	}
+
+	// The manifest is read before the workspace files, so fetch the branch
+	// rather than falling back to a checkout over the workspace.
+	content, err := getFileContentFromBranch(repoPath, branch, manifestPath)
+	if err != nil {
+		if ferr := fetchRef(repoPath, branch); ferr != nil {
+			return nil, ferr
+		}
+		content, err = getFileContentFromBranch(repoPath, fetchedRef(branch), manifestPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s from trusted branch: %w", manifestPath, err)
+	}
+	if jwsKey != nil {
+		payload, err := verifyJWS(content, jwsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify the signature of manifest %s: %w", manifestPath, err)
+		}
+		content = string(payload)
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal([]byte(content), &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", manifestPath, err)
+	}
+	for i, entry := range m.Files {
+		if (entry.Path == "") == (entry.Dir == "") {
+			return nil, fmt.Errorf("manifest entry %d must set exactly one of path or dir", i+1)
+		}
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("manifest entry %d: %w", i+1, err)
+		}
+	}
+	return &m, nil
+}
+
+// apply schedules every manifest entry on the plan.
+func (m *manifest) apply(plan *verificationPlan) error {
+	for _, entry := range m.Files {
+		var err error
+		if entry.Dir != "" {
+			err = plan.addDirectory(entry.Dir, entry.compareOptions)
+		} else {
+			err = plan.addFiles([]string{entry.Path}, entry.compareOptions)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// systemCABundles are the usual locations of the system CA bundle, which git
+// no longer reads once http.sslCAInfo is set.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/cert.pem",
+}
+
+// configureProxy sets the proxy environment variables, which git, through
+// libcurl, and the API clients both honor. It must run before the first API
+// request, since Go reads them only once. curl only reads the lowercase
+// http_proxy, so both spellings are set. A SOCKS5 proxy, e.g.
+// socks5h://bastion:1080, is used for the schemes without a proxy of their
+// own; both curl and Go understand it in these variables.
+func configureProxy(httpProxy, httpsProxy, noProxy, socksProxy string) error {
+	if socksProxy != "" {
+		if !strings.HasPrefix(socksProxy, "socks5://") && !strings.HasPrefix(socksProxy, "socks5h://") {
+			return fmt.Errorf("socks_proxy must be a socks5:// or socks5h:// URL")
+		}
+		if httpProxy == "" {
+			httpProxy = socksProxy
+		}
+		if httpsProxy == "" {
+			httpsProxy = socksProxy
+		}
+	}
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", httpProxy},
+		{"HTTPS_PROXY", httpsProxy},
+		{"NO_PROXY", noProxy},
+	} {
+		if v.value == "" {
+			continue
+		}
+		for _, name := range []string{v.name, strings.ToLower(v.name)} {
+			if err := os.Setenv(name, v.value); err != nil {
+				return fmt.Errorf("failed to set %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configureCACert trusts an additional CA, given as inline PEM or a path,
+// for git and the API clients, e.g. the internal CA of a GitHub Enterprise
+// Server. git gets a temporary bundle of the system CAs and the new CA,
+// which the returned function removes again.
+func configureCACert(cert string) (func(), error) {
+	pemData := []byte(cert)
+	if !strings.Contains(cert, "-----BEGIN") {
+		var err error
+		if pemData, err = os.ReadFile(cert); err != nil {
+			return nil, fmt.Errorf("failed to read ca_cert: %w", err)
+		}
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("ca_cert contains no PEM encoded certificates")
+	}
+	apiTLSConfig().RootCAs = pool
+
+	var bundle []byte
+	for _, path := range systemCABundles {
+		if data, err := os.ReadFile(path); err == nil {
+			bundle = append(data, '\n')
+			break
+		}
+	}
+	bundle = append(bundle, pemData...)
+	tmp, err := os.CreateTemp("", "trusted-ca-*.pem")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	_, err = tmp.Write(bundle)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to write the CA bundle: %w", err)
+	}
+	if err := setGitConfig("http.sslCAInfo", tmp.Name()); err != nil {
+		cleanup()
+		return nil, err
+	}
+	// GIT_SSL_CAINFO takes precedence over the config.
+	if os.Getenv("GIT_SSL_CAINFO") != "" {
+		if err := os.Setenv("GIT_SSL_CAINFO", tmp.Name()); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to set GIT_SSL_CAINFO: %w", err)
+		}
+	}
+	return cleanup, nil
+}
+
+// configureClientCert presents a client certificate to git and the API
+// clients, for gateways that require mutual TLS. The certificate and key are
+// inline PEM or paths; inline ones are written to private temporary files
+// for git, which the returned function removes again.
+func configureClientCert(cert, key string) (func(), error) {
+	if cert == "" || key == "" {
+		return nil, fmt.Errorf("client_cert and client_key must be set together")
+	}
+	dir, err := os.MkdirTemp("", "trusted-tls-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	var paths, contents [2]string
+	for i, value := range []string{cert, key} {
+		setting := []string{"client_cert", "client_key"}[i]
+		if strings.Contains(value, "-----BEGIN") {
+			paths[i] = filepath.Join(dir, setting+".pem")
+			if err := os.WriteFile(paths[i], []byte(value), 0600); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("failed to write %s: %w", setting, err)
+			}
+			contents[i] = value
+			continue
+		}
+		data, err := os.ReadFile(value)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to read %s: %w", setting, err)
+		}
+		paths[i], contents[i] = value, string(data)
+	}
+	pair, err := tls.X509KeyPair([]byte(contents[0]), []byte(contents[1]))
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to load client_cert and client_key: %w", err)
+	}
+	apiTLSConfig().Certificates = []tls.Certificate{pair}
+
+	if err := setGitConfig("http.sslCert", paths[0]); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := setGitConfig("http.sslKey", paths[1]); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return cleanup, nil
+}
+
+// configureSkipTLSVerify disables certificate verification for git and the
+// API clients. It is meant for short-lived test environments only.
+func configureSkipTLSVerify() error {
+	logrus.Warn("WARNING: TLS certificate verification is disabled by skip_tls_verify. " +
+		"Trusted files may be read from an impostor; never use this in production.")
+	apiTLSConfig().InsecureSkipVerify = true
+	if err := setGitConfig("http.sslVerify", "false"); err != nil {
+		return err
+	}
+	// GIT_SSL_NO_VERIFY is honored regardless of the config.
+	return os.Setenv("GIT_SSL_NO_VERIFY", "true")
+}
+
+// apiTLSConfig returns the TLS config of the transport the API clients
+// share.
+func apiTLSConfig() *tls.Config {
+	transport := http.DefaultTransport.(*http.Transport)
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	return transport.TLSClientConfig
+}
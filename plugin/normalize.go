@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// utf8BOM is the byte order mark some Windows editors prepend to UTF-8 files.
+const utf8BOM = "\xef\xbb\xbf"
+
+// Policies for UTF-8 byte order marks.
+const (
+	bomPolicyStrip = "strip"
+	bomPolicyFail  = "fail"
+)
+
+// Markers delimiting regions of a file that may differ from the trusted version.
+const (
+	ignoreStartMarker = "trusted:ignore-start"
+	ignoreEndMarker   = "trusted:ignore-end"
+)
+
+// normalizer transforms both sides of a comparison before they are compared.
+type normalizer struct {
+	name  string
+	apply func(string) string
+}
+
+// normalizers returns the normalization steps enabled by the options, in the
+// order they are applied.
+func (o compareOptions) normalizers() []normalizer {
+	var steps []normalizer
+	if o.BOM == bomPolicyStrip {
+		steps = append(steps, normalizer{name: "byte order mark", apply: stripBOM})
+	}
+	if o.RenderEnv {
+		steps = append(steps, normalizer{name: "environment variables", apply: func(content string) string {
+			return renderEnv(content, o.RenderEnvVars)
+		}})
+	}
+	if o.NormalizeEOL {
+		steps = append(steps, normalizer{name: "line endings", apply: normalizeEOL})
+	}
+	if o.IgnoreWhitespace {
+		steps = append(steps, normalizer{name: "whitespace", apply: normalizeWhitespace})
+	}
+	if o.IgnoreMarkers {
+		steps = append(steps, normalizer{name: "ignored regions", apply: stripIgnoredRegions})
+	}
+	if len(o.IgnorePatterns) > 0 {
+		patterns := make([]*regexp.Regexp, len(o.IgnorePatterns))
+		for i, p := range o.IgnorePatterns {
+			patterns[i] = regexp.MustCompile(p)
+		}
+		steps = append(steps, normalizer{name: "ignored patterns", apply: func(content string) string {
+			return maskPatterns(content, patterns)
+		}})
+	}
+	// Comments are stripped last so ignore markers are still visible above.
+	if o.StripComments {
+		steps = append(steps, normalizer{name: "comments", apply: stripComments})
+	}
+	return steps
+}
+
+// stripBOM removes a leading UTF-8 byte order mark.
+func stripBOM(content string) string {
+	return strings.TrimPrefix(content, utf8BOM)
+}
+
+// envPlaceholder matches envsubst style ${VAR} and $VAR references.
+var envPlaceholder = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// renderEnv substitutes environment variables the way envsubst does: unset
+// variables render as empty strings. When vars is not empty only the listed
+// variables are substituted and every other reference is left as is.
+func renderEnv(content string, vars []string) string {
+	return envPlaceholder.ReplaceAllStringFunc(content, func(ref string) string {
+		m := envPlaceholder.FindStringSubmatch(ref)
+		name := m[1] + m[2]
+		if len(vars) > 0 && !slices.Contains(vars, name) {
+			return ref
+		}
+		return os.Getenv(name)
+	})
+}
+
+// normalizeEOL converts CRLF and lone CR line endings to LF.
+func normalizeEOL(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.ReplaceAll(content, "\r", "\n")
+}
+
+// normalizeWhitespace trims trailing whitespace from every line and
+// collapses runs of blank lines into one, so formatting-only edits do not
+// count as differences while a blank line separating two lines still does.
+func normalizeWhitespace(content string) string {
+	lines := strings.Split(content, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r\f\v")
+		if line == "" && len(kept) > 0 && kept[len(kept)-1] == "" {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// stripIgnoredRegions drops the lines between ignore-start and ignore-end
+// markers. The marker lines themselves are kept, so markers added on the
+// current branch still cause a mismatch.
+func stripIgnoredRegions(content string) string {
+	lines := strings.Split(content, "\n")
+	kept := lines[:0]
+	ignoring := false
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, ignoreStartMarker):
+			ignoring = true
+		case strings.Contains(line, ignoreEndMarker):
+			ignoring = false
+		case ignoring:
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// maskPatterns blanks out every match of the patterns, line by line. Only the
+// matched text is removed, so lines added on the current branch still differ.
+func maskPatterns(content string, patterns []*regexp.Regexp) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		for _, p := range patterns {
+			line = p.ReplaceAllString(line, "")
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stripComments removes shell and YAML style "#" comments. Comment-only lines
+// are dropped and inline comments are cut along with the whitespace before
+// them. A leading shebang line is kept since it selects the interpreter.
+func stripComments(content string) string {
+	lines := strings.Split(content, "\n")
+	kept := lines[:0]
+	for i, line := range lines {
+		if i == 0 && strings.HasPrefix(line, "#!") {
+			kept = append(kept, line)
+			continue
+		}
+		stripped := stripLineComment(line)
+		if stripped == "" && strings.TrimSpace(line) != "" {
+			continue
+		}
+		kept = append(kept, stripped)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// stripLineComment cuts a "#" comment from a single line. A "#" only starts a
+// comment at the beginning of the line or after whitespace, outside of quotes.
+func stripLineComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return line
+}
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultOIDCExchangeURL is the Octo STS service that exchanges OIDC tokens
+// for GitHub tokens scoped by a trust policy in the repository.
+const defaultOIDCExchangeURL = "https://octo-sts.dev"
+
+// azureDevOpsScope is the Entra ID scope of Azure DevOps.
+const azureDevOpsScope = "499b84ac-1321-427f-aa17-267ca6975798/.default"
+
+// oidcExchange holds the runner's OIDC identity token and the settings that
+// trade it for short-lived credentials at the provider.
+type oidcExchange struct {
+	token       string
+	exchangeURL string
+	identity    string
+	tenantID    string
+	clientID    string
+	roleARN     string
+}
+
+// exchange trades the identity token for a repository token. CodeCommit
+// receives temporary AWS credentials in the environment instead and no token
+// is returned.
+func (o oidcExchange) exchange(provider string) (string, error) {
+	switch provider {
+	case providerGitHub:
+		return o.github()
+	case providerAzure:
+		return o.azure()
+	case providerCodeCommit:
+		return "", o.aws()
+	}
+	return "", fmt.Errorf("oidc_token is not supported for provider %s", provider)
+}
+
+// github exchanges the token with an Octo STS compatible service, which
+// checks it against the trust policy named by oidc_identity in the
+// repository and returns a token limited to the permissions of that policy.
+func (o oidcExchange) github() (string, error) {
+	if o.identity == "" {
+		return "", fmt.Errorf("oidc_identity is required to exchange an OIDC token for GitHub")
+	}
+	repo, err := githubRepo()
+	if err != nil {
+		return "", err
+	}
+	base := o.exchangeURL
+	if base == "" {
+		base = defaultOIDCExchangeURL
+	}
+	query := url.Values{"scope": {repo}, "identity": {o.identity}}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+"/sts/exchange?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.token)
+	var out struct {
+		Token string `json:"token"`
+	}
+	body, err := oidcDo(req)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &out); err != nil || out.Token == "" {
+		return "", fmt.Errorf("failed to exchange the OIDC token for a GitHub token: unexpected response")
+	}
+	logrus.Infof("Using a GitHub token for %s from the %s trust policy", repo, o.identity)
+	return out.Token, nil
+}
+
+// azure exchanges the token for an Entra ID access token of Azure DevOps,
+// using workload identity federation on the app registration or managed
+// identity named by oidc_client_id.
+func (o oidcExchange) azure() (string, error) {
+	if o.tenantID == "" || o.clientID == "" {
+		return "", fmt.Errorf("oidc_tenant_id and oidc_client_id are required to exchange an OIDC token for Azure DevOps")
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {o.clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {o.token},
+		"scope":                 {azureDevOpsScope},
+	}
+	endpoint := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(o.tenantID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := oidcDo(req)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || out.AccessToken == "" {
+		return "", fmt.Errorf("failed to exchange the OIDC token for an Entra ID token: unexpected response")
+	}
+	logrus.Infof("Using an Entra ID token of client %s for Azure DevOps", o.clientID)
+	return out.AccessToken, nil
+}
+
+// aws assumes oidc_role_arn with the token and exports the temporary
+// credentials, which the CodeCommit API picks up from the environment.
+func (o oidcExchange) aws() error {
+	if o.roleARN == "" {
+		return fmt.Errorf("oidc_role_arn is required to exchange an OIDC token for AWS credentials")
+	}
+	creds, expiration, err := assumeRoleWithWebIdentity(o.roleARN, o.token)
+	if err != nil {
+		return fmt.Errorf("failed to exchange the OIDC token for AWS credentials: %w", err)
+	}
+	registerSecret(creds.secretAccessKey, creds.sessionToken)
+	os.Setenv("AWS_ACCESS_KEY_ID", creds.accessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", creds.secretAccessKey)
+	os.Setenv("AWS_SESSION_TOKEN", creds.sessionToken)
+	logrus.Infof("Assumed %s, valid until %s", o.roleARN, expiration.Format(time.RFC3339))
+	return nil
+}
+
+// oidcDo sends a token exchange request and returns the response body. The
+// body of a failed exchange is included, since it explains why the token was
+// rejected, but never contains credentials.
+func oidcDo(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the OIDC token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the OIDC token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange the OIDC token at %s: %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
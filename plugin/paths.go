@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// cleanRelativePath validates a repository relative path and returns it in
+// clean, slash separated form. Absolute paths and paths that climb out of the
+// repository with ".." are rejected.
+func cleanRelativePath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("path is empty")
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("path %q contains a NUL byte", p)
+	}
+	if filepath.IsAbs(p) || filepath.VolumeName(p) != "" || strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) {
+		return "", fmt.Errorf("path %q must be relative to the repository root", p)
+	}
+	for _, segment := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return "", fmt.Errorf("path %q must not contain '..'", p)
+		}
+	}
+	return path.Clean(filepath.ToSlash(p)), nil
+}
+
+// maxSymlinkHops bounds the dangling symlinks resolveInRepo follows, as the
+// kernel bounds symlink chains.
+const maxSymlinkHops = 40
+
+// resolveInRepo joins a clean relative path with the repository root and
+// ensures that, after resolving symlinks, it still points inside the repository.
+func resolveInRepo(repoPath, rel string) (string, error) {
+	full := filepath.Join(repoPath, filepath.FromSlash(rel))
+
+	root, err := filepath.EvalSymlinks(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve repository path %s: %w", repoPath, err)
+	}
+
+	// Resolve the deepest existing ancestor so missing files can still be checked.
+	existing, rest := full, ""
+	hops := 0
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			existing = filepath.Join(resolved, rest)
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("failed to resolve %s: %w", full, err)
+		}
+		// A dangling symlink is followed to its missing target, which may
+		// lie outside of the repository.
+		if target, err := os.Readlink(existing); err == nil {
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(existing), target)
+			}
+			if hops++; hops > maxSymlinkHops {
+				return "", fmt.Errorf("failed to resolve %s: too many levels of symbolic links", full)
+			}
+			existing = target
+			continue
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", fmt.Errorf("failed to resolve %s: %w", full, err)
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	within, err := filepath.Rel(root, existing)
+	if err != nil || within == ".." || strings.HasPrefix(within, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("path %s resolves outside of the repository", rel)
+	}
+	return full, nil
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanRelativePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "build.sh", want: "build.sh"},
+		{path: "ci/build.sh", want: "ci/build.sh"},
+		{path: "./ci//sub/./build.sh", want: "ci/sub/build.sh"},
+		{path: "ci/", want: "ci"},
+		{path: "", wantErr: true},
+		{path: "..", wantErr: true},
+		{path: "../build.sh", wantErr: true},
+		{path: "ci/../build.sh", wantErr: true},
+		{path: "ci/../../etc/passwd", wantErr: true},
+		{path: `ci\..\build.sh`, wantErr: true},
+		{path: "/etc/passwd", wantErr: true},
+		{path: `\etc\passwd`, wantErr: true},
+		{path: "build\x00.sh", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := cleanRelativePath(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("cleanRelativePath(%q) = %q, want an error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("cleanRelativePath(%q) failed: %v", tt.path, err)
+		} else if got != tt.want {
+			t.Errorf("cleanRelativePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResolveInRepo(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(repo, "ci"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(repo, "ci", "build.sh"), filepath.Join(outside, "secret")} {
+		if err := os.WriteFile(f, []byte("echo\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside":        "ci/build.sh",
+		"chain":         "inside",
+		"cidir":         "ci",
+		"escape":        "../outside/secret",
+		"escape-abs":    filepath.Join(outside, "secret"),
+		"escape-dir":    "../outside",
+		"escape-chain":  "escape",
+		"via-dir":       "cidir/../escape",
+		"dangling":      "../outside/missing",
+		"dangling-in":   "ci/missing",
+		"dangling-loop": "dangling-loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(repo, name)); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		rel     string
+		wantErr bool
+	}{
+		{rel: "ci/build.sh"},
+		{rel: "ci/missing.sh"},
+		{rel: "missing/dir/file"},
+		{rel: "inside"},
+		{rel: "chain"},
+		{rel: "cidir/build.sh"},
+		{rel: "dangling-in"},
+		{rel: "escape", wantErr: true},
+		{rel: "escape-abs", wantErr: true},
+		{rel: "escape-dir/secret", wantErr: true},
+		{rel: "escape-dir/missing", wantErr: true},
+		{rel: "escape-chain", wantErr: true},
+		{rel: "via-dir", wantErr: true},
+		{rel: "dangling", wantErr: true},
+		{rel: "dangling-loop", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveInRepo(repo, tt.rel)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveInRepo(%q) = %q, want an error", tt.rel, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveInRepo(%q) failed: %v", tt.rel, err)
+		} else if want := filepath.Join(repo, filepath.FromSlash(tt.rel)); got != want {
+			t.Errorf("resolveInRepo(%q) = %q, want %q", tt.rel, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// patternPolicy gates content with regular expressions: every required
+// pattern must match and no forbidden pattern may match. Both the current file
+// and its trusted version are checked, since normalization can let a line
+// through the comparison that only exists on one side. Patterns are compiled
+// in multi-line mode, so ^ and $ match at line boundaries.
+type patternPolicy struct {
+	require []contentPattern
+	forbid  []contentPattern
+}
+
+// contentPattern is a compiled pattern along with its source for messages.
+type contentPattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// newPatternPolicy compiles the required and forbidden patterns.
+func newPatternPolicy(require, forbid []string) (patternPolicy, error) {
+	var p patternPolicy
+	var err error
+	if p.require, err = compileContentPatterns("required", require); err != nil {
+		return patternPolicy{}, err
+	}
+	if p.forbid, err = compileContentPatterns("forbidden", forbid); err != nil {
+		return patternPolicy{}, err
+	}
+	return p, nil
+}
+
+func compileContentPatterns(kind string, sources []string) ([]contentPattern, error) {
+	patterns := make([]contentPattern, 0, len(sources))
+	for _, source := range sources {
+		re, err := regexp.Compile("(?m)" + source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", kind, source, err)
+		}
+		patterns = append(patterns, contentPattern{source: source, re: re})
+	}
+	return patterns, nil
+}
+
+func (p patternPolicy) check(repoPath, ref, filePath string) (string, error) {
+	trusted, err := readTrustedFile(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	currentPath, err := resolveInRepo(repoPath, filePath)
+	if err != nil {
+		return "", err
+	}
+	current, err := os.ReadFile(currentPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", currentPath, err)
+	}
+
+	versions := []struct {
+		name    string
+		content string
+	}{
+		{"current", string(current)},
+		{"trusted", trusted},
+	}
+	for _, version := range versions {
+		for _, pattern := range p.require {
+			if !pattern.re.MatchString(version.content) {
+				return fmt.Sprintf("%s version does not match required pattern %q", version.name, pattern.source), nil
+			}
+		}
+		for _, pattern := range p.forbid {
+			if loc := pattern.re.FindStringIndex(version.content); loc != nil {
+				return fmt.Sprintf("%s version matches forbidden pattern %q at %q", version.name, pattern.source, version.content[loc[0]:loc[1]]), nil
+			}
+		}
+	}
+	return "", nil
+}
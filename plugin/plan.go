@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// verificationPlan collects the files to verify, how each one is compared,
+// and the problems found while resolving them.
+type verificationPlan struct {
+	repoPath      string
+	trustedBranch string
+	// trustedRefs lists every trusted ref in order of precedence, starting
+	// with trustedBranch.
+	trustedRefs   []string
+	currentBranch string
+	exclude       excluder
+	// detectHidden reports hidden unicode characters added on the current branch.
+	detectHidden bool
+	// history allows matching earlier versions on the trusted branch.
+	history historyLimit
+	// policies are checked for every file that matched its trusted version.
+	policies []filePolicy
+
+	files   []string
+	options map[string]compareOptions
+	// refs holds the trusted ref each file is compared against when there
+	// is more than one.
+	refs map[string]string
+	// results holds files that failed while the plan was resolved.
+	results []fileResult
+}
+
+func newVerificationPlan(repoPath string, trustedRefs []string, currentBranch string, exclude excluder) *verificationPlan {
+	return &verificationPlan{
+		repoPath:      repoPath,
+		trustedBranch: trustedRefs[0],
+		trustedRefs:   trustedRefs,
+		currentBranch: currentBranch,
+		exclude:       exclude,
+		options:       make(map[string]compareOptions),
+		refs:          make(map[string]string),
+	}
+}
+
+// selectRefs picks, for every file, the first trusted ref in order of
+// precedence where the file exists. Files missing from every ref are compared
+// against the first ref, which reports them as missing.
+func (p *verificationPlan) selectRefs() error {
+	if len(p.trustedRefs) < 2 {
+		return nil
+	}
+	for _, filePath := range p.files {
+		for _, ref := range p.trustedRefs {
+			_, err := resolveTrustedRef(p.repoPath, ref, filePath)
+			var missing *MissingTrustedFileError
+			if errors.As(err, &missing) {
+				continue
+			}
+			if err != nil {
+				logrus.Warnf("Skipping trusted ref %s for %s: %v", ref, filePath, err)
+				continue
+			}
+			if ref != p.trustedBranch {
+				logrus.Infof("File %s does not exist on %s, using %s", filePath, describeTrustedRef(p.trustedBranch), describeTrustedRef(ref))
+			}
+			p.refs[filePath] = ref
+			break
+		}
+	}
+	return nil
+}
+
+// refFor returns the trusted ref a file is compared against.
+func (p *verificationPlan) refFor(filePath string) string {
+	if ref, ok := p.refs[filePath]; ok {
+		return ref
+	}
+	return p.trustedBranch
+}
+
+// usedRefs lists the distinct trusted refs the scheduled files are compared
+// against, in order of precedence. Without any scheduled file it is just the
+// first ref.
+func (p *verificationPlan) usedRefs() []string {
+	used := map[string]bool{p.trustedBranch: len(p.files) == 0}
+	for _, filePath := range p.files {
+		used[p.refFor(filePath)] = true
+	}
+	var refs []string
+	for _, ref := range p.trustedRefs {
+		if used[ref] {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// addFiles schedules the given paths, expanding glob patterns against the
+// trusted branch. Exclude patterns only apply to files matched by a glob.
+func (p *verificationPlan) addFiles(paths []string, opts compareOptions) error {
+	cleaned := make([]string, 0, len(paths))
+	for _, raw := range paths {
+		c, err := cleanRelativePath(raw)
+		if err != nil {
+			return fmt.Errorf("invalid file path: %w", err)
+		}
+		cleaned = append(cleaned, c)
+	}
+	expanded, err := expandFilePaths(p.repoPath, p.trustedBranch, cleaned, p.exclude)
+	if err != nil {
+		return fmt.Errorf("failed to expand file patterns: %w", err)
+	}
+	p.schedule(expanded, opts)
+	return nil
+}
+
+// addDirectory schedules every file under dir and records files that were
+// added or removed on the current branch as mismatches.
+func (p *verificationPlan) addDirectory(dir string, opts compareOptions) error {
+	dir, err := cleanRelativePath(dir)
+	if err != nil {
+		return fmt.Errorf("invalid directory path: %w", err)
+	}
+	if _, err := resolveInRepo(p.repoPath, dir); err != nil {
+		return fmt.Errorf("invalid directory path: %w", err)
+	}
+	common, added, removed, err := diffDirectory(p.repoPath, p.trustedBranch, dir, p.exclude)
+	if err != nil {
+		return fmt.Errorf("failed to compare directory %s: %w", dir, err)
+	}
+	for _, f := range added {
+		logrus.Warnf("File %s was added on branch '%s'", f, p.currentBranch)
+		result := fileResult{Path: f, Reason: reasonAdded}
+		if full, err := resolveInRepo(p.repoPath, f); err == nil {
+			if content, err := os.ReadFile(full); err == nil {
+				result.SHA256 = sha256Hex(string(content))
+			}
+		}
+		p.fail(result)
+	}
+	for _, f := range removed {
+		logrus.Warnf("File %s was removed on branch '%s'", f, p.currentBranch)
+		p.fail(fileResult{Path: f, Reason: reasonRemoved})
+	}
+	p.schedule(common, opts)
+	return nil
+}
+
+// fail records a file that failed verification while resolving the plan.
+func (p *verificationPlan) fail(result fileResult) {
+	for _, r := range p.results {
+		if r.Path == result.Path {
+			return
+		}
+	}
+	p.results = append(p.results, result)
+}
+
+// unschedule removes a file from the plan.
+func (p *verificationPlan) unschedule(file string) {
+	for i, f := range p.files {
+		if f == file {
+			p.files = append(p.files[:i:i], p.files[i+1:]...)
+			break
+		}
+	}
+	delete(p.options, file)
+}
+
+// schedule adds files to the plan. The first options registered for a file win.
+func (p *verificationPlan) schedule(files []string, opts compareOptions) {
+	for _, f := range files {
+		if _, ok := p.options[f]; ok {
+			continue
+		}
+		p.options[f] = opts
+		p.files = append(p.files, f)
+	}
+}
@@ -2,34 +2,139 @@ package plugin
 
 import (
 	"context"
+	"crypto"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
 
 // Args represents the plugin input arguments.
 type Args struct {
-	RepoPath      string `envconfig:"PLUGIN_REPO_PATH"`
-	FilePath      string `envconfig:"PLUGIN_FILE_PATH" required:"true"`
-	TrustedBranch string `envconfig:"PLUGIN_TRUSTED_BRANCH" required:"true"`
-	CurrentBranch string `envconfig:"PLUGIN_CURRENT_BRANCH"`
-	GitPat        string `envconfig:"PLUGIN_GIT_PAT"`
+	RepoPath          string `envconfig:"PLUGIN_REPO_PATH"`
+	FilePath          string `envconfig:"PLUGIN_FILE_PATH"`
+	FilePaths         string `envconfig:"PLUGIN_FILE_PATHS"`
+	DirPath           string `envconfig:"PLUGIN_DIR_PATH"`
+	ManifestPath      string `envconfig:"PLUGIN_MANIFEST_PATH"`
+	CompareMode       string `envconfig:"PLUGIN_COMPARE_MODE"`
+	NormalizeEOL      bool   `envconfig:"PLUGIN_NORMALIZE_EOL"`
+	IgnoreWhitespace  bool   `envconfig:"PLUGIN_IGNORE_WHITESPACE"`
+	IgnoreMarkers     bool   `envconfig:"PLUGIN_IGNORE_MARKERS"`
+	IgnorePatterns    string `envconfig:"PLUGIN_IGNORE_PATTERNS"`
+	RenderEnv         bool   `envconfig:"PLUGIN_RENDER_ENV"`
+	RenderEnvVars     string `envconfig:"PLUGIN_RENDER_ENV_VARS"`
+	StripComments     bool   `envconfig:"PLUGIN_STRIP_COMMENTS"`
+	SOPS              bool   `envconfig:"PLUGIN_SOPS"`
+	SOPSAgeKey        string `envconfig:"PLUGIN_SOPS_AGE_KEY"`
+	Provider          string `envconfig:"PLUGIN_PROVIDER"`
+	Strategy          string `envconfig:"PLUGIN_STRATEGY"`
+	GitHost           string `envconfig:"PLUGIN_GIT_HOST"`
+	GitHosts          string `envconfig:"PLUGIN_GIT_HOSTS"`
+	GitCredentials    string `envconfig:"PLUGIN_GIT_CREDENTIALS"`
+	Netrc             bool   `envconfig:"PLUGIN_NETRC"`
+	APIURL            string `envconfig:"PLUGIN_API_URL"`
+	HTTPProxy         string `envconfig:"PLUGIN_HTTP_PROXY"`
+	HTTPSProxy        string `envconfig:"PLUGIN_HTTPS_PROXY"`
+	NoProxy           string `envconfig:"PLUGIN_NO_PROXY"`
+	SOCKSProxy        string `envconfig:"PLUGIN_SOCKS_PROXY"`
+	CACert            string `envconfig:"PLUGIN_CA_CERT"`
+	SkipTLSVerify     bool   `envconfig:"PLUGIN_SKIP_TLS_VERIFY"`
+	ClientCert        string `envconfig:"PLUGIN_CLIENT_CERT"`
+	ClientKey         string `envconfig:"PLUGIN_CLIENT_KEY"`
+	GitHubAppID       string `envconfig:"PLUGIN_GITHUB_APP_ID"`
+	GitHubAppKey      string `envconfig:"PLUGIN_GITHUB_APP_PRIVATE_KEY"`
+	GitHubInstallID   string `envconfig:"PLUGIN_GITHUB_APP_INSTALLATION_ID"`
+	OIDCToken         string `envconfig:"PLUGIN_OIDC_TOKEN"`
+	OIDCExchangeURL   string `envconfig:"PLUGIN_OIDC_EXCHANGE_URL"`
+	OIDCIdentity      string `envconfig:"PLUGIN_OIDC_IDENTITY"`
+	OIDCTenantID      string `envconfig:"PLUGIN_OIDC_TENANT_ID"`
+	OIDCClientID      string `envconfig:"PLUGIN_OIDC_CLIENT_ID"`
+	OIDCRoleARN       string `envconfig:"PLUGIN_OIDC_ROLE_ARN"`
+	GitUsername       string `envconfig:"PLUGIN_GIT_USERNAME"`
+	SSHKey            string `envconfig:"PLUGIN_SSH_KEY"`
+	SSHKnownHosts     string `envconfig:"PLUGIN_SSH_KNOWN_HOSTS"`
+	Age               bool   `envconfig:"PLUGIN_AGE"`
+	AgeIdentity       string `envconfig:"PLUGIN_AGE_IDENTITY"`
+	TrustedFilePaths  string `envconfig:"PLUGIN_TRUSTED_FILE_PATHS"`
+	BOMPolicy         string `envconfig:"PLUGIN_BOM_POLICY"`
+	DetectHidden      bool   `envconfig:"PLUGIN_DETECT_HIDDEN_UNICODE" default:"true"`
+	MaxFileSize       string `envconfig:"PLUGIN_MAX_FILE_SIZE"`
+	MaxFileSizeAction string `envconfig:"PLUGIN_MAX_FILE_SIZE_ACTION" default:"fail"`
+	Exclude           string `envconfig:"PLUGIN_EXCLUDE"`
+	DeletedPolicy     string `envconfig:"PLUGIN_DELETED_FILE_POLICY" default:"fail"`
+	Concurrency       int    `envconfig:"PLUGIN_CONCURRENCY" default:"1"`
+	TrustedRef        string `envconfig:"PLUGIN_TRUSTED_REF"`
+	TrustedBranch     string `envconfig:"PLUGIN_TRUSTED_BRANCH"`
+	TrustedSemver     string `envconfig:"PLUGIN_TRUSTED_SEMVER"`
+	MergeBase         bool   `envconfig:"PLUGIN_MERGE_BASE"`
+	AllowHistorical   bool   `envconfig:"PLUGIN_ALLOW_HISTORICAL"`
+	HistoryDepth      int    `envconfig:"PLUGIN_HISTORY_DEPTH" default:"100"`
+	HistorySince      string `envconfig:"PLUGIN_HISTORY_SINCE"`
+	SkipUntouched     bool   `envconfig:"PLUGIN_SKIP_UNTOUCHED"`
+	RequireAncestor   bool   `envconfig:"PLUGIN_REQUIRE_ANCESTOR"`
+	MaxAgeDays        int    `envconfig:"PLUGIN_MAX_AGE_DAYS"`
+	MaxAgeAction      string `envconfig:"PLUGIN_MAX_AGE_ACTION" default:"fail"`
+	TrustedAuthors    string `envconfig:"PLUGIN_TRUSTED_AUTHORS"`
+	RequireSigned     bool   `envconfig:"PLUGIN_REQUIRE_SIGNED_COMMITS"`
+	SignedSince       string `envconfig:"PLUGIN_SIGNED_COMMITS_SINCE"`
+	RequireGPGSig     bool   `envconfig:"PLUGIN_REQUIRE_GPG_SIGNATURE"`
+	GPGPublicKeys     string `envconfig:"PLUGIN_GPG_PUBLIC_KEYS"`
+	SSHAllowedSigners string `envconfig:"PLUGIN_SSH_ALLOWED_SIGNERS"`
+	CosignKey         string `envconfig:"PLUGIN_COSIGN_KEY"`
+	CosignIdentity    string `envconfig:"PLUGIN_COSIGN_IDENTITY"`
+	CosignIssuer      string `envconfig:"PLUGIN_COSIGN_OIDC_ISSUER"`
+	AttestationKey    string `envconfig:"PLUGIN_ATTESTATION_KEY"`
+	AttestationPath   string `envconfig:"PLUGIN_ATTESTATION_PATH"`
+	RegoPolicy        string `envconfig:"PLUGIN_REGO_POLICY"`
+	CUESchema         string `envconfig:"PLUGIN_CUE_SCHEMA"`
+	CUEDefinition     string `envconfig:"PLUGIN_CUE_DEFINITION"`
+	SchemaPath        string `envconfig:"PLUGIN_SCHEMA_PATH"`
+	RequirePatterns   string `envconfig:"PLUGIN_REQUIRE_PATTERNS"`
+	ForbidPatterns    string `envconfig:"PLUGIN_FORBID_PATTERNS"`
+	SecretScan        string `envconfig:"PLUGIN_SECRET_SCAN" default:"off"`
+	RequireCodeowners bool   `envconfig:"PLUGIN_REQUIRE_CODEOWNER_APPROVAL"`
+	RequireProtection bool   `envconfig:"PLUGIN_REQUIRE_BRANCH_PROTECTION"`
+	ProtectionAction  string `envconfig:"PLUGIN_BRANCH_PROTECTION_ACTION" default:"fail"`
+	ApprovalTeam      string `envconfig:"PLUGIN_APPROVAL_TEAM"`
+	MinApprovals      int    `envconfig:"PLUGIN_MIN_APPROVALS" default:"1"`
+	ExpectedSHA256    string `envconfig:"PLUGIN_EXPECTED_SHA256"`
+	JWSPublicKey      string `envconfig:"PLUGIN_JWS_PUBLIC_KEY"`
+	ExpectedHMAC      string `envconfig:"PLUGIN_EXPECTED_HMAC"`
+	HMACSecret        string `envconfig:"PLUGIN_HMAC_SECRET"`
+	ExpectChange      bool   `envconfig:"PLUGIN_EXPECT_CHANGE"`
+	CurrentBranch     string `envconfig:"PLUGIN_CURRENT_BRANCH"`
+	GitPat            string `envconfig:"PLUGIN_GIT_PAT"`
+	GitPatFrom        string `envconfig:"PLUGIN_GIT_PAT_FROM"`
+	VaultAddr         string `envconfig:"PLUGIN_VAULT_ADDR"`
+	VaultPath         string `envconfig:"PLUGIN_VAULT_PATH"`
+	VaultKey          string `envconfig:"PLUGIN_VAULT_KEY"`
+	VaultRoleID       string `envconfig:"PLUGIN_VAULT_ROLE_ID"`
+	VaultSecretID     string `envconfig:"PLUGIN_VAULT_SECRET_ID"`
+	VaultRole         string `envconfig:"PLUGIN_VAULT_ROLE"`
+	VaultAuthMount    string `envconfig:"PLUGIN_VAULT_AUTH_MOUNT"`
+	GitBackend        string `envconfig:"PLUGIN_GIT_BACKEND" default:"auto"`
+	GitTimeout        string `envconfig:"PLUGIN_GIT_TIMEOUT"`
+	Timeout           string `envconfig:"PLUGIN_TIMEOUT"`
+	Retries           int    `envconfig:"PLUGIN_RETRIES" default:"2"`
+	RetryBackoff      string `envconfig:"PLUGIN_RETRY_BACKOFF" default:"1s"`
+	FetchDepth        int    `envconfig:"PLUGIN_FETCH_DEPTH" default:"1"`
+	PartialFetch      bool   `envconfig:"PLUGIN_PARTIAL_FETCH" default:"true"`
 }
 
 // Exec runs the plugin logic.
 func Exec(ctx context.Context, args Args) (err error) {
-	// We'll write the final TRUSTED output only once at the end.
+	// We'll write the final TRUSTED output only once at the end, or as false
+	// when the timeout stops the run first.
 	resultTrusted := "false"
-	defer func() {
-		if werr := WriteEnvToFile("TRUSTED", resultTrusted); werr != nil {
-			logrus.Warnf("Failed to write TRUSTED variable: %v", werr)
-		}
-	}()
+	openOutputs()
+	defer func() { closeOutputs(resultTrusted) }()
 
 	repoPath := args.RepoPath
 	if repoPath == "" {
@@ -38,6 +143,97 @@ func Exec(ctx context.Context, args Args) (err error) {
 			return fmt.Errorf("repo_path is not set and DRONE_WORKSPACE is unavailable")
 		}
 	}
+	if err := validateSecretScan(args.SecretScan); err != nil {
+		return err
+	}
+	// Credentials are masked in every log line and error.
+	registerSecret(args.GitPat, args.OIDCToken, args.VaultSecretID, args.GitHubAppKey, args.HMACSecret,
+		args.SOPSAgeKey, args.AgeIdentity, args.SSHKey, args.ClientKey,
+		os.Getenv("CI_JOB_TOKEN"), os.Getenv("DRONE_NETRC_PASSWORD"), os.Getenv("VAULT_TOKEN"),
+		os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"))
+	// Subprocesses are killed when the context is canceled or the run ends.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	commandCtx = ctx
+	if gitTimeout, err = parseDuration("git_timeout", args.GitTimeout); err != nil {
+		return err
+	}
+	timeout, err := parseDuration("timeout", args.Timeout)
+	if err != nil {
+		return err
+	}
+	backoff, err := parseDuration("retry_backoff", args.RetryBackoff)
+	if err != nil {
+		return err
+	}
+	if err := configureRetries(args.Retries, backoff); err != nil {
+		return err
+	}
+	if args.FetchDepth < 0 {
+		return fmt.Errorf("invalid fetch_depth %d: expected 0 or more", args.FetchDepth)
+	}
+	fetchDepth = args.FetchDepth
+	if readsTrustedHistory(args) {
+		fetchDepth = 0
+	}
+	partialFetch = args.PartialFetch
+	if timeout > 0 {
+		defer exitOnTimeout(timeout, func() bool {
+			if !closeOutputs("false") {
+				return false
+			}
+			cancel()
+			return true
+		})()
+	}
+	// Credentials and temporary files are removed however the run ends.
+	defer runCleanups()
+	defer cleanupOnSignal(cancel)()
+
+	if gitOps, err = selectGitBackend(args.GitBackend); err != nil {
+		return err
+	}
+	if args.Netrc && !usesGitBinary() {
+		return fmt.Errorf("netrc requires the git binary, go-git does not read ~/.netrc")
+	}
+	if settings := gitBinarySettings(args); !usesGitBinary() && len(settings) > 0 {
+		if !gitInstalled() {
+			return fmt.Errorf("%s require the git binary, which is not installed", strings.Join(settings, ", "))
+		}
+	}
+	// git settings of the plugin go to a temporary global config, never the
+	// user's own. go-git reads no git config.
+	if usesGitBinary() {
+		cleanupGitConfig, err := configureGitConfig()
+		if err != nil {
+			return err
+		}
+		registerCleanup(cleanupGitConfig)
+	}
+
+	// Proxy and TLS settings apply to git and the API clients alike.
+	if err := configureProxy(args.HTTPProxy, args.HTTPSProxy, args.NoProxy, args.SOCKSProxy); err != nil {
+		return err
+	}
+	if args.CACert != "" {
+		cleanupCACert, err := configureCACert(args.CACert)
+		if err != nil {
+			return err
+		}
+		registerCleanup(cleanupCACert)
+	}
+	if args.ClientCert != "" || args.ClientKey != "" {
+		cleanupClientCert, err := configureClientCert(args.ClientCert, args.ClientKey)
+		if err != nil {
+			return err
+		}
+		registerCleanup(cleanupClientCert)
+	}
+	if args.SkipTLSVerify {
+		if err := configureSkipTLSVerify(); err != nil {
+			return err
+		}
+	}
 
 	if args.CurrentBranch == "" {
 		var err error
@@ -47,99 +243,784 @@ func Exec(ctx context.Context, args Args) (err error) {
 		}
 	}
 
-	if args.GitPat != "" {
-		if err := configureGitCredentials(args.GitPat); err != nil {
+	// A signed manifest or signed digests cannot be changed by a compromise
+	// of the trusted branch or the pipeline settings alone.
+	var jwsKey crypto.PublicKey
+	if args.JWSPublicKey != "" {
+		if args.ManifestPath == "" && args.ExpectedSHA256 == "" {
+			return fmt.Errorf("jws_public_key requires manifest_path or expected_sha256")
+		}
+		if jwsKey, err = parseJWSPublicKey(args.JWSPublicKey); err != nil {
+			return err
+		}
+	}
+
+	// Hermetic mode verifies pinned digests or HMACs and never touches the
+	// trusted branch.
+	if args.ExpectedSHA256 != "" && args.ExpectedHMAC != "" {
+		return fmt.Errorf("expected_sha256 and expected_hmac cannot be combined")
+	}
+	if (args.ExpectedHMAC == "") != (args.HMACSecret == "") {
+		return fmt.Errorf("expected_hmac and hmac_secret must be set together")
+	}
+	if args.ExpectedSHA256 != "" || args.ExpectedHMAC != "" {
+		var results []fileResult
+		against := "the expected SHA-256 digests"
+		if args.ExpectedHMAC != "" {
+			against = "the expected HMACs"
+			macs, order, err := parseExpectedHMACs(args.ExpectedHMAC, collectFilePaths(args))
+			if err != nil {
+				return err
+			}
+			if results, err = verifyExpectedHMACs(repoPath, args.HMACSecret, macs, order); err != nil {
+				return err
+			}
+		} else {
+			expected := args.ExpectedSHA256
+			if jwsKey != nil {
+				payload, err := verifyJWS(expected, jwsKey)
+				if err != nil {
+					return fmt.Errorf("failed to verify the signature of expected_sha256: %w", err)
+				}
+				expected = string(payload)
+			}
+			digests, order, err := parseExpectedDigests(expected, collectFilePaths(args))
+			if err != nil {
+				return err
+			}
+			if results, err = verifyExpectedDigests(repoPath, digests, order); err != nil {
+				return err
+			}
+		}
+		if args.ExpectChange {
+			return reportChanges(results, args.CurrentBranch, against)
+		}
+		if err := reportResults(results, args.CurrentBranch, against); err != nil {
+			return err
+		}
+
+		// The exported file is scanned for secrets first, so a failed scan
+		// never leaves TRUSTED=true behind.
+		if len(results) == 1 {
+			content, err := readWorkspaceFile(repoPath, results[0].Path)
+			if err != nil {
+				return err
+			}
+			if err := exportTrustedFile(repoPath, "", results[0].Path, compareOptions{}, results[0], content, args.SecretScan); err != nil {
+				return err
+			}
+		}
+		resultTrusted = "true"
+		logrus.Infof("File content matches %s. Validation succeeded.", against)
+		return nil
+	}
+
+	// trusted_branch is the original name of trusted_ref.
+	if args.TrustedRef == "" {
+		args.TrustedRef = args.TrustedBranch
+	}
+	// sops reads its age key from the environment.
+	if args.SOPSAgeKey != "" {
+		if err := os.Setenv("SOPS_AGE_KEY", args.SOPSAgeKey); err != nil {
+			return fmt.Errorf("failed to configure sops age key: %w", err)
+		}
+	}
+	// age only reads identities from files.
+	if args.AgeIdentity != "" {
+		cleanup, err := configureAgeIdentity(args.AgeIdentity)
+		if err != nil {
+			return err
+		}
+		registerCleanup(cleanup)
+	}
+	// SSH remotes fetch with the key instead of git_pat.
+	if args.SSHKey != "" {
+		cleanup, err := configureSSHKey(args.SSHKey, args.SSHKnownHosts)
+		if err != nil {
+			return err
+		}
+		registerCleanup(cleanup)
+	}
+
+	// Parse the attestation key up front so a bad key fails before verifying.
+	var attest *attestation
+	if args.AttestationPath != "" && args.AttestationKey == "" {
+		return fmt.Errorf("attestation_path requires attestation_key")
+	}
+	if args.AttestationKey != "" {
+		if attest, err = newAttestation(args.AttestationKey, args.AttestationPath); err != nil {
+			return err
+		}
+	}
+
+	// The provider API is only read when asked for, by a strategy or by
+	// naming the provider.
+	apiSelected := args.Provider != "" && args.Provider != providerAuto && args.Provider != providerGit
+	if retrievalSources, err = parseStrategy(args.Strategy, apiSelected); err != nil {
+		return err
+	}
+	// The git token can be read from AWS or Vault instead of a pipeline secret.
+	if args.GitPatFrom != "" {
+		if args.GitPat != "" || args.VaultPath != "" {
+			return fmt.Errorf("git_pat_from cannot be combined with git_pat or vault_path")
+		}
+		if args.GitPat, err = readAWSSecret(args.GitPatFrom); err != nil {
+			return err
+		}
+	}
+	if args.VaultPath != "" {
+		if args.GitPat != "" {
+			return fmt.Errorf("vault_path cannot be combined with git_pat")
+		}
+		vault := vaultSource{
+			addr:      args.VaultAddr,
+			path:      args.VaultPath,
+			key:       args.VaultKey,
+			roleID:    args.VaultRoleID,
+			secretID:  args.VaultSecretID,
+			role:      args.VaultRole,
+			authMount: args.VaultAuthMount,
+		}
+		if args.GitPat, err = vault.read(); err != nil {
+			return err
+		}
+	}
+	// A GitHub App installation token replaces git_pat for git and the API.
+	if args.GitHubAppID != "" || args.GitHubAppKey != "" {
+		if args.GitHubAppID == "" || args.GitHubAppKey == "" {
+			return fmt.Errorf("github_app_id and github_app_private_key must be set together")
+		}
+		_, host := resolveProvider(repoPath, providerGitHub, args.GitHost, "")
+		configureGitHubAPI(host, args.APIURL)
+		if args.GitPat, err = githubAppToken(args.GitHubAppID, args.GitHubAppKey, args.GitHubInstallID); err != nil {
+			return err
+		}
+	}
+	// The runner's OIDC token is exchanged for short-lived credentials, so no
+	// secret has to be stored for the provider.
+	if args.OIDCToken != "" {
+		if args.GitPat != "" {
+			return fmt.Errorf("oidc_token cannot be combined with git_pat or a GitHub App")
+		}
+		oidcProvider := selectProvider(repoPath, args.Provider)
+		if oidcProvider == providerGitHub {
+			_, host := resolveProvider(repoPath, providerGitHub, args.GitHost, "")
+			configureGitHubAPI(host, args.APIURL)
+		}
+		exchange := oidcExchange{
+			token:       args.OIDCToken,
+			exchangeURL: args.OIDCExchangeURL,
+			identity:    args.OIDCIdentity,
+			tenantID:    args.OIDCTenantID,
+			clientID:    args.OIDCClientID,
+			roleARN:     args.OIDCRoleARN,
+		}
+		if args.GitPat, err = exchange.exchange(oidcProvider); err != nil {
+			return err
+		}
+	}
+	// The token may have been read or exchanged above.
+	registerSecret(args.GitPat)
+	provider, host := resolveProvider(repoPath, args.Provider, args.GitHost, args.GitPat)
+	if provider == providerGitHub || isGitHubHost(host) {
+		configureGitHubAPI(host, args.APIURL)
+	}
+	creds, err := gitCredentials(provider, host, args)
+	if err != nil {
+		return err
+	}
+	for _, cred := range creds {
+		registerSecret(cred.token)
+	}
+	switch {
+	case len(creds) == 0:
+	case args.Netrc:
+		restoreNetrc, err := writeNetrc(creds)
+		if err != nil {
+			return err
+		}
+		registerCleanup(restoreNetrc)
+	default:
+		if err := configureGitCredentials(creds); err != nil {
 			return fmt.Errorf("failed to configure git credentials: %w", err)
 		}
 	}
 
-	// Attempt lightweight access: get the file content from the trusted branch.
-	trustedContent, err := getFileContentFromBranch(repoPath, args.TrustedBranch, args.FilePath)
+	// Read trusted files through the provider API when the strategy allows it.
+	if allowsSource(sourceAPI) {
+		if trustedAPI, err = newProviderReader(repoPath, provider, strings.TrimSuffix(args.APIURL, "/"), args.GitUsername, args.GitPat); err != nil {
+			if apiSelected {
+				return err
+			}
+			logrus.Warnf("Failed to set up the %s API, reading trusted files with git: %v", provider, err)
+		}
+	}
+	if len(usableSources()) == 0 {
+		return fmt.Errorf("strategy %q needs a provider API, but none is configured", args.Strategy)
+	}
+
+	trustedRefs, err := resolveTrustedRefs(repoPath, args.TrustedRef, args.TrustedSemver)
 	if err != nil {
-		logrus.Warnf("Lightweight access failed: %v. Falling back to heavyweight checkout...", err)
-		trustedContent, err = checkoutAndReadFile(repoPath, args.TrustedBranch, args.FilePath)
+		return err
+	}
+	// Builds that did not touch the protected files have nothing to verify.
+	if args.SkipUntouched {
+		skipped := untouchedInBuild(repoPath, trustedRefs[0], args)
+		if err := WriteEnvToFile("TRUSTED_SKIPPED", strconv.FormatBool(skipped)); err != nil {
+			return fmt.Errorf("failed to write TRUSTED_SKIPPED: %w", err)
+		}
+		if skipped {
+			resultTrusted = "true"
+			logrus.Info("Protected files were not modified in this build. Verification skipped.")
+			return nil
+		}
+	}
+
+	// Compare against where the current branch was cut, so changes merged
+	// into the trusted branch since then do not count against it.
+	if args.MergeBase {
+		for i, ref := range trustedRefs {
+			if trustedRefs[i], err = mergeBase(repoPath, ref); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := validateDeletedPolicy(args.DeletedPolicy); err != nil {
+		return err
+	}
+	limit, err := newSizeLimit(args.MaxFileSize, args.MaxFileSizeAction)
+	if err != nil {
+		return err
+	}
+
+	filePaths := collectFilePaths(args)
+	if len(filePaths) == 0 && args.DirPath == "" && args.ManifestPath == "" {
+		return fmt.Errorf("file_path, file_paths, dir_path or manifest_path must be set")
+	}
+
+	exclude := parseExcludes(splitList(args.Exclude))
+	plan := newVerificationPlan(repoPath, trustedRefs, args.CurrentBranch, exclude)
+	plan.detectHidden = args.DetectHidden
+	plan.history = historyLimit{enabled: args.AllowHistorical, depth: args.HistoryDepth, since: args.HistorySince}
+	if plan.history.enabled && plan.history.depth <= 0 {
+		return fmt.Errorf("history_depth must be positive")
+	}
+	if args.MaxAgeDays > 0 {
+		if err := validatePolicyAction("max_age_action", args.MaxAgeAction); err != nil {
+			return err
+		}
+		plan.policies = append(plan.policies, agePolicy{maxDays: args.MaxAgeDays, action: args.MaxAgeAction})
+	}
+	if authors := splitList(args.TrustedAuthors); len(authors) > 0 {
+		plan.policies = append(plan.policies, newAuthorPolicy(authors, args.GitPat))
+	}
+	if args.RequireSigned || args.RequireGPGSig {
+		if args.GPGPublicKeys == "" {
+			return fmt.Errorf("require_signed_commits and require_gpg_signature need gpg_public_keys")
+		}
+		keyring, err := newGPGKeyring(args.GPGPublicKeys)
+		if err != nil {
+			return err
+		}
+		defer keyring.Close()
+		if args.RequireSigned {
+			plan.policies = append(plan.policies, signedCommitsPolicy{keyring: keyring, since: args.SignedSince})
+		}
+		if args.RequireGPGSig {
+			plan.policies = append(plan.policies, gpgSignaturePolicy{keyring: keyring})
+		}
+	}
+	if args.SSHAllowedSigners != "" {
+		policy, err := newSSHSignaturePolicy(args.SSHAllowedSigners)
 		if err != nil {
-			return fmt.Errorf("heavyweight checkout failed: %w", err)
+			return err
+		}
+		defer policy.Close()
+		plan.policies = append(plan.policies, policy)
+	}
+	if args.CosignKey != "" || args.CosignIdentity != "" || args.CosignIssuer != "" {
+		if args.CosignKey == "" && (args.CosignIdentity == "" || args.CosignIssuer == "") {
+			return fmt.Errorf("keyless cosign verification needs both cosign_identity and cosign_oidc_issuer")
+		}
+		plan.policies = append(plan.policies, cosignPolicy{key: args.CosignKey, identity: args.CosignIdentity, issuer: args.CosignIssuer})
+	}
+	if args.CUEDefinition != "" && args.CUESchema == "" {
+		return fmt.Errorf("cue_definition requires cue_schema")
+	}
+	if args.CUESchema != "" {
+		policy, err := newCUEPolicy(repoPath, trustedRefs[0], args.CUESchema, args.CUEDefinition)
+		if err != nil {
+			return err
+		}
+		plan.policies = append(plan.policies, policy)
+	}
+	if args.SchemaPath != "" {
+		policy, err := newSchemaPolicy(repoPath, trustedRefs[0], args.SchemaPath)
+		if err != nil {
+			return err
+		}
+		plan.policies = append(plan.policies, policy)
+	}
+	if args.RequireProtection {
+		if err := validatePolicyAction("branch_protection_action", args.ProtectionAction); err != nil {
+			return err
+		}
+	}
+	if args.RequireCodeowners {
+		policy, err := newCodeownersPolicy(repoPath, trustedRefs[0], args.GitPat)
+		if err != nil {
+			return err
+		}
+		plan.policies = append(plan.policies, policy)
+	}
+	if args.RequirePatterns != "" || args.ForbidPatterns != "" {
+		policy, err := newPatternPolicy(splitLines(args.RequirePatterns), splitLines(args.ForbidPatterns))
+		if err != nil {
+			return err
+		}
+		plan.policies = append(plan.policies, policy)
+	}
+	defaultOptions := defaultCompareOptions(args)
+	if err := defaultOptions.validate(); err != nil {
+		return err
+	}
+	if args.ManifestPath != "" {
+		m, err := loadManifest(repoPath, trustedRefs[0], args.ManifestPath, jwsKey)
+		if err != nil {
+			return err
+		}
+		if err := m.apply(plan); err != nil {
+			return err
+		}
+	}
+	var approvals *approvalOverride
+	if args.ApprovalTeam != "" {
+		if approvals, err = newApprovalOverride(args.ApprovalTeam, args.MinApprovals, args.GitPat); err != nil {
+			return err
+		}
+	}
+	var rego *regoPolicy
+	if args.RegoPolicy != "" {
+		if rego, err = loadRegoPolicy(repoPath, trustedRefs[0], args.RegoPolicy); err != nil {
+			return err
+		}
+	}
+	if len(filePaths) > 0 {
+		if err := plan.addFiles(filePaths, defaultOptions); err != nil {
+			return err
+		}
+	}
+	if args.DirPath != "" {
+		if err := plan.addDirectory(args.DirPath, defaultOptions); err != nil {
+			return err
 		}
 	}
+	if len(plan.files) == 0 && len(plan.results) == 0 {
+		return fmt.Errorf("no files to verify")
+	}
 
-	// For the current branch, read the file directly from the filesystem.
-	currentFilePath := filepath.Join(repoPath, args.FilePath)
-	currentContentBytes, err := os.ReadFile(currentFilePath)
+	if err := plan.selectRefs(); err != nil {
+		return err
+	}
+
+	// Read every file from the current branch before touching the trusted
+	// branch, since the heavyweight fallback checks it out over the workspace.
+	currentFiles, err := readCurrentFiles(plan, args.DeletedPolicy, limit)
 	if err != nil {
-		return fmt.Errorf("failed to read file from current branch at %s: %w", currentFilePath, err)
+		return err
 	}
-	currentContent := string(currentContentBytes)
+	// With deleted_file_policy warn, deleted files are skipped. A run that
+	// compared nothing proves nothing, so it never passes.
+	if len(plan.files) == 0 && len(plan.results) == 0 {
+		return fmt.Errorf("no files were verified, every protected file was deleted on the current branch")
+	}
+	filePaths = plan.files
 
-	// Compare file contents.
-	if trustedContent != currentContent {
-		return fmt.Errorf("file content mismatch between branch '%s' and trusted branch '%s'", args.CurrentBranch, args.TrustedBranch)
+	// Builds on the trusted ref itself have nothing to compare against.
+	var verified []fileResult
+	var trustedContents []string
+	shortcut := onTrustedRef(plan)
+	if shortcut {
+		logrus.Infof("Build runs on %s, skipping comparison", describeTrustedRef(plan.trustedBranch))
+		verified, trustedContents = trustedShortcutResults(plan, currentFiles)
+	} else if verified, trustedContents, err = verifyFiles(plan, currentFiles, args.Concurrency); err != nil {
+		return err
+	}
+	if approvals != nil {
+		if err := approvals.apply(plan, verified); err != nil {
+			return err
+		}
+	}
+	if rego != nil {
+		if err := rego.apply(plan, verified, trustedContents, currentFiles); err != nil {
+			return err
+		}
+	}
+	results := append(plan.results, verified...)
+
+	// Export the refs and commits that were actually verified against, so
+	// later steps can pin to them even if the trusted branch moves.
+	usedRefs := plan.usedRefs()
+	usedCommits, err := exportTrustedRefs(repoPath, usedRefs)
+	if err != nil {
+		return err
+	}
+
+	against := describeTrustedRefs(usedRefs)
+	// In expect-change mode a match is the failure case.
+	if args.ExpectChange {
+		return reportChanges(results, args.CurrentBranch, against)
+	}
+	if err := reportResults(results, args.CurrentBranch, against); err != nil {
+		return err
+	}
+	if args.RequireAncestor {
+		for _, ref := range usedRefs {
+			if err := checkAncestry(repoPath, ref); err != nil {
+				return err
+			}
+		}
+	}
+	if args.RequireProtection {
+		provider := selectProvider(repoPath, args.Provider)
+		for _, ref := range usedRefs {
+			if err := checkBranchProtection(repoPath, provider, ref, args.GitPat, args.ProtectionAction); err != nil {
+				return err
+			}
+		}
+	}
+	if attest != nil {
+		if err := attest.write(repoPath, results, usedRefs, usedCommits, args.CurrentBranch); err != nil {
+			return err
+		}
+	}
+
+	// TRUSTED_FILE_CONTENT only makes sense when a single file was verified.
+	// It is scanned for secrets before TRUSTED is set, so a failed scan
+	// never leaves TRUSTED=true behind.
+	if len(filePaths) == 1 {
+		// On the trusted ref, HEAD is the trusted version and needs no fetch.
+		exportRef := plan.refFor(filePaths[0])
+		if shortcut {
+			exportRef = "HEAD"
+		}
+		if err := exportTrustedFile(repoPath, exportRef, filePaths[0], plan.options[filePaths[0]], verified[0], trustedContents[0], args.SecretScan); err != nil {
+			return err
+		}
 	}
 
 	// Verification succeeded.
 	resultTrusted = "true"
+	logrus.Infof("File content matches the %s. Validation succeeded.", against)
+	return nil
+}
+
+// exportTrustedFile exports the content and digest of the single verified file.
+// For binary files and in sha256 mode only the digest is exported. The content
+// is scanned for secrets according to secretScan before it is exported.
+func exportTrustedFile(repoPath, branch, filePath string, opts compareOptions, result fileResult, trustedContent, secretScan string) error {
+	if opts.Mode == compareModeSHA256 {
+		return WriteEnvToFile("TRUSTED_FILE_SHA256", result.SHA256)
+	}
+
+	if !opts.readsContent() {
+		var err error
+		if trustedContent, err = readTrustedFile(repoPath, branch, filePath); err != nil {
+			return err
+		}
+	}
+
+	// The plaintext of a SOPS or age file is only ever exported as a secret output.
+	switch {
+	case opts.SOPS:
+		if err := exportDecryptedFile(filePath, trustedContent, decryptSOPS); err != nil {
+			return err
+		}
+	case opts.Age:
+		if err := exportDecryptedFile(filePath, trustedContent, decryptAge); err != nil {
+			return err
+		}
+	}
+
+	// Exporting a binary blob would bloat the output file, so only export its digest.
+	if isBinary(trustedContent) {
+		logrus.Infof("File %s is binary, exporting only TRUSTED_FILE_SHA256", filePath)
+		return WriteEnvToFile("TRUSTED_FILE_SHA256", sha256Hex(trustedContent))
+	}
+
+	// The exported value is visible to later steps, so never leak secrets.
+	exported, err := applySecretScan(secretScan, filePath, trustedContent)
+	if err != nil {
+		return err
+	}
 
 	// Encode the file content in Base64.
-	encodedContent := base64.StdEncoding.EncodeToString([]byte(trustedContent))
+	encodedContent := base64.StdEncoding.EncodeToString([]byte(exported))
 
 	// Export TRUSTED_FILE_CONTENT as an output variable.
 	if err := WriteEnvToFile("TRUSTED_FILE_CONTENT", encodedContent); err != nil {
 		return fmt.Errorf("failed to write TRUSTED_FILE_CONTENT: %w", err)
 	}
+	return WriteEnvToFile("TRUSTED_FILE_SHA256", sha256Hex(trustedContent))
+}
 
-	logrus.Info("File content matches the trusted branch. Validation succeeded.")
+// exportDecryptedFile exports the decrypted content of a SOPS or age file as
+// the secret output TRUSTED_FILE_DECRYPTED_CONTENT.
+func exportDecryptedFile(filePath, trustedContent string, decrypt func(filePath, content string) (string, error)) error {
+	if os.Getenv("HARNESS_OUTPUT_SECRET_FILE") == "" {
+		logrus.Warnf("HARNESS_OUTPUT_SECRET_FILE is not set, not exporting the decrypted content of %s", filePath)
+		return nil
+	}
+	plaintext, err := decrypt(filePath, trustedContent)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(plaintext))
+	if err := WriteSecretEnvToFile("TRUSTED_FILE_DECRYPTED_CONTENT", encoded); err != nil {
+		return fmt.Errorf("failed to write TRUSTED_FILE_DECRYPTED_CONTENT: %w", err)
+	}
 	return nil
 }
 
+// collectFilePaths merges file_path and file_paths into a de-duplicated list.
+func collectFilePaths(args Args) []string {
+	var paths []string
+	if args.FilePath != "" {
+		paths = append(paths, args.FilePath)
+	}
+	return appendUnique(paths, splitList(args.FilePaths)...)
+}
+
 func getCurrentBranch(repoPath string) (string, error) {
-	cmd := exec.Command("git", "-C", repoPath, "rev-parse", "--abbrev-ref", "HEAD")
-	output, err := cmd.Output()
-	if err != nil {
+	return gitOps.currentBranch(repoPath)
+}
+
+// checkoutMu serializes heavyweight checkouts of the trusted branch.
+var checkoutMu sync.Mutex
+
+// readTrustedFile reads a file from the trusted branch, trying the sources of
+// the retrieval strategy in order: by default the local ref, then a
+// heavyweight checkout.
+func readTrustedFile(repoPath, branch, filePath string) (string, error) {
+	return readFromSources(repoPath, branch, filePath, true)
+}
+
+// resolveTrustedRef returns a local ref holding the trusted version of the
+// file, fetching the branch from origin when it is not available locally and
+// the strategy allows it.
+func resolveTrustedRef(repoPath, branch, filePath string) (string, error) {
+	if allowsSource(sourceLocal) && fileExistsInRef(repoPath, branch, filePath) {
+		return branch, nil
+	}
+	if !allowsSource(sourceFetch) {
+		if allowsSource(sourceLocal) && refExists(repoPath, branch) {
+			return "", &MissingTrustedFileError{Path: filePath, Branch: branch}
+		}
+		return "", fmt.Errorf("%s is not available locally and the strategy does not allow fetching it", describeTrustedRef(branch))
+	}
+
+	checkoutMu.Lock()
+	defer checkoutMu.Unlock()
+	if err := fetchRef(repoPath, branch); err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(output)), nil
+	if !fileExistsInRef(repoPath, fetchedRef(branch), filePath) {
+		return "", &MissingTrustedFileError{Path: filePath, Branch: branch}
+	}
+	return fetchedRef(branch), nil
 }
 
-// configureGitCredentials sets up Git credentials in a cross-platform manner.
-func configureGitCredentials(gitPat string) error {
-	cmd := exec.Command("git", "config", "--global", "credential.helper", "store")
-	if err := cmd.Run(); err != nil {
-		return err
+// readOptionalTrustedFile reads a companion file, such as a signature, from
+// the trusted ref without falling back to a checkout. It reports false when
+// the file does not exist.
+func readOptionalTrustedFile(repoPath, branch, filePath string) (string, bool, error) {
+	content, err := readFromSources(repoPath, branch, filePath, false)
+	var missing *MissingTrustedFileError
+	if errors.As(err, &missing) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return content, true, nil
+}
+
+// readFromSources tries the sources of the strategy in order. A file missing
+// from the provider API or origin is reported as missing right away, while a
+// local ref may be stale and only counts when it is the last source. Unless
+// checkout is set, the fetch source never checks the trusted ref out.
+func readFromSources(repoPath, branch, filePath string, checkout bool) (string, error) {
+	sources := usableSources()
+	if len(sources) == 0 {
+		return "", fmt.Errorf("cannot read %s: the strategy only allows the provider API, but no provider is configured", filePath)
+	}
+	var err error
+	for i, source := range sources {
+		var content string
+		content, err = readFromSource(source, repoPath, branch, filePath, checkout)
+		if err == nil {
+			return content, nil
+		}
+		var missing *MissingTrustedFileError
+		if errors.As(err, &missing) && source != sourceLocal {
+			return "", err
+		}
+		if i == len(sources)-1 {
+			break
+		}
+		if errors.As(err, &missing) {
+			logrus.Warnf("File %s not found on local branch %s. Falling back to %s...", filePath, branch, describeSource(sources[i+1]))
+		} else {
+			logrus.Warnf("%v. Falling back to %s...", err, describeSource(sources[i+1]))
+		}
+	}
+	return "", err
+}
+
+// readFromSource reads a file from the trusted ref through a single source.
+func readFromSource(source, repoPath, branch, filePath string, checkout bool) (string, error) {
+	switch source {
+	case sourceAPI:
+		content, found, err := trustedAPI.read(branch, filePath)
+		if err == nil && !found {
+			return "", &MissingTrustedFileError{Path: filePath, Branch: branch}
+		}
+		return content, err
+	case sourceLocal:
+		content, err := getFileContentFromBranch(repoPath, branch, filePath)
+		if err == nil {
+			return content, nil
+		}
+		if refExists(repoPath, branch) && !fileExistsInRef(repoPath, branch, filePath) {
+			return "", &MissingTrustedFileError{Path: filePath, Branch: branch}
+		}
+		return "", fmt.Errorf("lightweight access failed for %s: %w", filePath, err)
 	}
 
-	home, err := os.UserHomeDir()
+	// The fallback fetches into the workspace repository, so only one file
+	// may use it at a time.
+	checkoutMu.Lock()
+	defer checkoutMu.Unlock()
+	// go-git only reads the fetched ref; checking out needs the git binary.
+	if !checkout || !usesGitBinary() {
+		if err := fetchRef(repoPath, branch); err != nil {
+			return "", err
+		}
+		if !fileExistsInRef(repoPath, fetchedRef(branch), filePath) {
+			return "", &MissingTrustedFileError{Path: filePath, Branch: branch}
+		}
+		content, err := getFileContentFromBranch(repoPath, fetchedRef(branch), filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s from %s: %w", filePath, fetchedRef(branch), err)
+		}
+		return content, nil
+	}
+	content, err := checkoutAndReadFile(repoPath, branch, filePath)
+	var missing *MissingTrustedFileError
+	if errors.As(err, &missing) {
+		return "", err
+	}
 	if err != nil {
-		return err
+		return "", fmt.Errorf("heavyweight checkout failed: %w", err)
 	}
-	credFilePath := filepath.Join(home, ".git-credentials")
-	// Use the recommended format for GitHub PAT authentication.
-	credContent := fmt.Sprintf("https://x-access-token:%s@github.com", gitPat)
-	return os.WriteFile(credFilePath, []byte(credContent), 0644)
+	return content, nil
 }
 
-func getFileContentFromBranch(repoPath, branch, filePath string) (string, error) {
-	cmd := exec.Command("git", "-C", repoPath, "show", fmt.Sprintf("%s:%s", branch, filePath))
-	output, err := cmd.Output()
+// readTrustedOID returns the blob object ID of a file on the trusted branch.
+func readTrustedOID(repoPath, branch, filePath string) (string, error) {
+	ref, err := resolveTrustedRef(repoPath, branch, filePath)
 	if err != nil {
 		return "", err
 	}
-	return string(output), nil
+	return revParse(repoPath, fmt.Sprintf("%s:%s", ref, filePath))
+}
+
+// hashTrustedFile streams a file from the trusted branch through SHA-256
+// without holding it in memory.
+func hashTrustedFile(repoPath, branch, filePath string) (string, error) {
+	ref, err := resolveTrustedRef(repoPath, branch, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := gitOps.openFile(repoPath, ref, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from %s: %w", filePath, ref, err)
+	}
+	digest, hashErr := sha256Reader(content)
+	if err := content.Close(); err != nil {
+		return "", fmt.Errorf("failed to read %s from %s: %w", filePath, ref, err)
+	}
+	if hashErr != nil {
+		return "", fmt.Errorf("failed to hash %s from %s: %w", filePath, ref, hashErr)
+	}
+	return digest, nil
+}
+
+// revParse resolves a revision to its object ID.
+func revParse(repoPath, rev string) (string, error) {
+	oid, err := gitOps.revParse(repoPath, rev)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+	return oid, nil
+}
+
+// hashObject computes the blob object ID git would assign to a workspace file.
+func hashObject(repoPath, filePath string) (string, error) {
+	oid, err := gitOps.hashObject(repoPath, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", filePath, err)
+	}
+	return oid, nil
 }
 
+// refExists reports whether ref resolves to a commit in the local repository.
+func refExists(repoPath, ref string) bool {
+	return gitOps.hasRef(repoPath, ref)
+}
+
+// fileExistsInRef reports whether the file exists in the tree of ref.
+func fileExistsInRef(repoPath, ref, filePath string) bool {
+	return gitOps.hasFile(repoPath, ref, filePath)
+}
+
+func getFileContentFromBranch(repoPath, branch, filePath string) (string, error) {
+	return gitOps.show(repoPath, branch, filePath)
+}
+
+// checkoutAndReadFile checks the file out of the fetched trusted ref into a
+// temporary worktree and reads it there. The workspace, which later steps
+// build, is never modified: HEAD, the branch and the index stay where they
+// were, so nothing has to be restored afterwards, even when reading fails.
 func checkoutAndReadFile(repoPath, branch, filePath string) (string, error) {
-	// Fetch the branch from remote.
-	fetchCmd := exec.Command("git", "-C", repoPath, "fetch", "origin", branch)
-	if err := fetchCmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to fetch branch %s: %w", branch, err)
+	if err := fetchRef(repoPath, branch); err != nil {
+		return "", err
 	}
 
-	// Check out the branch, updating/creating the local branch from origin.
-	checkoutCmd := exec.Command("git", "-C", repoPath, "checkout", "-B", branch, "origin/"+branch)
-	if err := checkoutCmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+	if !fileExistsInRef(repoPath, fetchedRef(branch), filePath) {
+		return "", &MissingTrustedFileError{Path: filePath, Branch: branch}
 	}
 
-	fullPath := filepath.Join(repoPath, filePath)
+	worktree, err := addTrustedWorktree(repoPath, fetchedRef(branch))
+	if err != nil {
+		return "", err
+	}
+	defer removeTrustedWorktree(repoPath, worktree)
+
+	// Check out only the directory of the file with a sparse checkout, so
+	// the worktree of a large repository stays small. A file at the root is
+	// checked out on its own.
+	dir := path.Dir(filePath)
+	if dir == "." {
+		dir = filePath
+	}
+	if err := sparseCheckout(worktree, dir); err != nil {
+		return "", fmt.Errorf("failed to checkout %s from %s: %w", dir, branch, err)
+	}
+
+	fullPath := filepath.Join(worktree, filePath)
 	content, err := os.ReadFile(fullPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file %s: %w", fullPath, err)
@@ -147,6 +1028,73 @@ func checkoutAndReadFile(repoPath, branch, filePath string) (string, error) {
 	return string(content), nil
 }
 
+// addTrustedWorktree adds a detached worktree of ref, without checking out
+// any files, in a new temporary directory.
+func addTrustedWorktree(repoPath, ref string) (string, error) {
+	dir, err := os.MkdirTemp("", "trusted-worktree-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	addCmd := command("git", "-C", repoPath, "worktree", "add", "--detach", "--no-checkout", dir, ref)
+	if err := runCommand(addCmd); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to add a worktree of %s: %w", ref, err)
+	}
+	return dir, nil
+}
+
+// sparseCheckout checks out a single file or directory in a worktree added
+// without a checkout. The sparse pattern goes to the worktree's own info
+// directory and sparse checkout is only enabled for this command, so the
+// config of the repository is left alone, unlike with git sparse-checkout.
+func sparseCheckout(worktree, filePath string) error {
+	if strings.ContainsAny(filePath, "\r\n") {
+		return fmt.Errorf("path %q contains a line break", filePath)
+	}
+	patternFile, err := gitOutput(worktree, "rev-parse", "--git-path", "info/sparse-checkout")
+	if err != nil {
+		return fmt.Errorf("failed to locate the sparse checkout file: %w", err)
+	}
+	if !filepath.IsAbs(patternFile) {
+		patternFile = filepath.Join(worktree, patternFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(patternFile), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(patternFile, []byte(sparsePattern(filePath)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return runCommand(command("git", "-C", worktree, "-c", "core.sparseCheckout=true", "checkout", "--quiet"))
+}
+
+// sparsePattern returns the sparse checkout pattern matching exactly one
+// path, and everything below it, from the root. Wildcards and trailing
+// spaces are escaped, since patterns follow the .gitignore syntax.
+func sparsePattern(filePath string) string {
+	var pattern strings.Builder
+	pattern.WriteByte('/')
+	for i, r := range filePath {
+		switch {
+		case strings.ContainsRune(`\*?[`, r):
+			pattern.WriteByte('\\')
+		case r == ' ' && strings.TrimRight(filePath[i:], " ") == "":
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteRune(r)
+	}
+	return pattern.String()
+}
+
+// removeTrustedWorktree removes a worktree added by addTrustedWorktree and
+// its administrative files in the repository.
+func removeTrustedWorktree(repoPath, dir string) {
+	if err := runCommand(command("git", "-C", repoPath, "worktree", "remove", "--force", dir)); err != nil {
+		logrus.Warnf("Failed to remove the temporary worktree %s: %v", dir, err)
+		os.RemoveAll(dir)
+		command("git", "-C", repoPath, "worktree", "prune").Run()
+	}
+}
+
 // package plugin
 
 // import (
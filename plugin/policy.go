@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// filePolicy is an additional requirement on the trusted version of a file,
+// checked once the current file matched it. A non-empty reason fails the file.
+type filePolicy interface {
+	check(repoPath, ref, filePath string) (string, error)
+}
+
+// Actions taken when a policy is violated.
+const (
+	policyActionFail = "fail"
+	policyActionWarn = "warn"
+)
+
+// validatePolicyAction checks that the action for the named setting is supported.
+func validatePolicyAction(setting, action string) error {
+	switch action {
+	case policyActionFail, policyActionWarn:
+		return nil
+	default:
+		return fmt.Errorf("unsupported %s %q, expected fail or warn", setting, action)
+	}
+}
+
+// agePolicy requires the trusted version of a file to have been changed
+// within maxDays, so stale files get re-reviewed periodically.
+type agePolicy struct {
+	maxDays int
+	action  string
+}
+
+func (p agePolicy) check(repoPath, ref, filePath string) (string, error) {
+	resolved, err := resolveTrustedRef(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	changed, err := lastChange(repoPath, resolved, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	age := int(math.Floor(time.Since(changed).Hours() / 24))
+	if age <= p.maxDays {
+		return "", nil
+	}
+	reason := fmt.Sprintf("trusted version last changed %d days ago, exceeding max_age_days of %d", age, p.maxDays)
+	if p.action == policyActionWarn {
+		logrus.Warnf("File %s: %s", filePath, reason)
+		return "", nil
+	}
+	return reason, nil
+}
+
+// lastChange returns the committer date of the last commit on ref touching filePath.
+func lastChange(repoPath, ref, filePath string) (time.Time, error) {
+	output, err := command("git", "-C", repoPath, "log", "-1", "--format=%ct", ref, "--", filePath).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the last change of %s on %s: %w", filePath, ref, err)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the last change of %s on %s: %w", filePath, ref, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
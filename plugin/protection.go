@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// branchProtection summarizes the rules guarding a branch on the provider.
+type branchProtection struct {
+	protected       bool
+	blocksForcePush bool
+	requiredReviews int
+}
+
+// problems lists the ways the protection falls short of what a trusted
+// branch needs.
+func (p branchProtection) problems() []string {
+	if !p.protected {
+		return []string{"it is not protected"}
+	}
+	var problems []string
+	if !p.blocksForcePush {
+		problems = append(problems, "it allows force pushes")
+	}
+	if p.requiredReviews < 1 {
+		problems = append(problems, "it does not require pull request reviews")
+	}
+	return problems
+}
+
+// checkBranchProtection asks the provider hosting origin, GitLab or GitHub,
+// whether a trusted branch is protected against force pushes and requires
+// reviews. Tags and commits are skipped. Depending on action a weak
+// protection fails or warns.
+func checkBranchProtection(repoPath, provider, ref, token, action string) error {
+	if isTagRef(ref) || isCommitRef(ref) {
+		logrus.Infof("Skipping the branch protection check for %s", describeTrustedRef(ref))
+		return nil
+	}
+	remote, err := readOriginRemote(repoPath)
+	if err != nil {
+		return err
+	}
+
+	var protection branchProtection
+	if provider == providerGitLab {
+		protection, err = gitlabBranchProtection(newGitLabClient(remote, token), remote.Path, ref)
+	} else {
+		protection, err = githubBranchProtection(ref, token)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the protection of %s: %w", describeTrustedRef(ref), err)
+	}
+
+	problems := protection.problems()
+	if len(problems) == 0 {
+		logrus.Infof("The %s is protected", describeTrustedRef(ref))
+		return nil
+	}
+	message := fmt.Sprintf("%s is not adequately protected: %s", describeTrustedRef(ref), strings.Join(problems, ", "))
+	if action == policyActionWarn {
+		logrus.Warnf("The %s", message)
+		return nil
+	}
+	return errors.New(message)
+}
+
+// githubBranchProtection combines classic branch protection with repository
+// rulesets. Reading classic protection needs admin access; without it only
+// rulesets are considered.
+func githubBranchProtection(branch, token string) (branchProtection, error) {
+	repo, err := githubRepo()
+	if err != nil {
+		return branchProtection{}, err
+	}
+	var p branchProtection
+
+	var classic struct {
+		AllowForcePushes struct {
+			Enabled bool `json:"enabled"`
+		} `json:"allow_force_pushes"`
+		RequiredPullRequestReviews *struct {
+			RequiredApprovingReviewCount int `json:"required_approving_review_count"`
+		} `json:"required_pull_request_reviews"`
+	}
+	status, err := githubGet(fmt.Sprintf("/repos/%s/branches/%s/protection", repo, url.PathEscape(branch)), token, &classic)
+	switch {
+	case err == nil:
+		p.protected = true
+		p.blocksForcePush = !classic.AllowForcePushes.Enabled
+		if classic.RequiredPullRequestReviews != nil {
+			p.requiredReviews = classic.RequiredPullRequestReviews.RequiredApprovingReviewCount
+		}
+	case status == http.StatusForbidden:
+		logrus.Warnf("No access to the classic branch protection of %s, checking rulesets only", branch)
+	case status != http.StatusNotFound:
+		return branchProtection{}, err
+	}
+
+	var rules []struct {
+		Type       string `json:"type"`
+		Parameters struct {
+			RequiredApprovingReviewCount int `json:"required_approving_review_count"`
+		} `json:"parameters"`
+	}
+	if _, err := githubGet(fmt.Sprintf("/repos/%s/rules/branches/%s", repo, url.PathEscape(branch)), token, &rules); err != nil {
+		return branchProtection{}, err
+	}
+	for _, rule := range rules {
+		p.protected = true
+		switch rule.Type {
+		case "non_fast_forward":
+			p.blocksForcePush = true
+		case "pull_request":
+			p.requiredReviews = max(p.requiredReviews, rule.Parameters.RequiredApprovingReviewCount)
+		}
+	}
+	return p, nil
+}
+
+// gitlabBranchProtection reads a protected branch and the merge request
+// approval rules that apply to it. Approval rules need GitLab Premium; when
+// they are unavailable no reviews are required.
+func gitlabBranchProtection(client gitlabClient, project, branch string) (branchProtection, error) {
+	var p branchProtection
+	var protected struct {
+		AllowForcePush bool `json:"allow_force_push"`
+	}
+	status, err := client.get(fmt.Sprintf("/projects/%s/protected_branches/%s", gitlabProject(project), url.PathEscape(branch)), &protected)
+	if status == http.StatusNotFound {
+		return p, nil
+	}
+	if err != nil {
+		return branchProtection{}, err
+	}
+	p.protected = true
+	p.blocksForcePush = !protected.AllowForcePush
+
+	var rules []struct {
+		ApprovalsRequired int `json:"approvals_required"`
+		ProtectedBranches []struct {
+			Name string `json:"name"`
+		} `json:"protected_branches"`
+	}
+	status, err = client.get(fmt.Sprintf("/projects/%s/approval_rules", gitlabProject(project)), &rules)
+	if status == http.StatusForbidden || status == http.StatusNotFound {
+		return p, nil
+	}
+	if err != nil {
+		return branchProtection{}, err
+	}
+	for _, rule := range rules {
+		// Rules without protected branches apply to every branch.
+		applies := len(rule.ProtectedBranches) == 0
+		for _, b := range rule.ProtectedBranches {
+			applies = applies || b.Name == branch
+		}
+		if applies {
+			p.requiredReviews = max(p.requiredReviews, rule.ApprovalsRequired)
+		}
+	}
+	return p, nil
+}
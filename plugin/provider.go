@@ -0,0 +1,276 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Providers the trusted files can be read from. Files are read with git
+// unless a provider API is selected. auto picks the provider from the origin
+// remote.
+const (
+	providerAuto       = "auto"
+	providerGit        = "git"
+	providerGitHub     = "github"
+	providerGitLab     = "gitlab"
+	providerBitbucket  = "bitbucket"
+	providerAzure      = "azure"
+	providerGitea      = "gitea"
+	providerHarness    = "harness"
+	providerCodeCommit = "codecommit"
+)
+
+// providerAPI reads files from the REST API of a git hosting provider, so
+// the trusted ref does not have to be available in the workspace.
+type providerAPI interface {
+	// commit resolves a branch, tag or commit to a commit SHA.
+	commit(ref string) (string, error)
+	// readFile reads a file at a commit. It reports false when the file does
+	// not exist.
+	readFile(commit, filePath string) (string, bool, error)
+}
+
+// providerReader reads trusted files through a provider API. Each trusted
+// ref is resolved to a commit once, so every file is read from the same
+// snapshot even if the branch moves during the build.
+type providerReader struct {
+	name string
+	api  providerAPI
+
+	mu      sync.Mutex
+	commits map[string]string
+}
+
+// trustedAPI is the provider API trusted files are read from first, or nil
+// when only git is used.
+var trustedAPI *providerReader
+
+// newProviderReader returns the reader for the provider setting, or nil for
+// git. apiURL overrides the API root of self-hosted GitLab, Gitea and Harness
+// instances; GitHub uses githubAPI.
+func newProviderReader(repoPath, provider, apiURL, username, token string) (*providerReader, error) {
+	var api providerAPI
+	switch provider {
+	case providerGit:
+		return nil, nil
+	case providerGitHub:
+		repo, err := githubRepo()
+		if err != nil {
+			return nil, err
+		}
+		api = githubContents{repo: repo, token: token}
+	case providerGitLab:
+		remote, err := readOriginRemote(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		client := newGitLabClient(remote, token)
+		if apiURL != "" {
+			client.baseURL = apiURL
+		}
+		api = gitlabFiles{client: client, project: remote.Path}
+	case providerBitbucket:
+		remote, err := readOriginRemote(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		api = bitbucketSrc{repo: remote.Path, username: username, token: token}
+	case providerAzure:
+		remote, err := readOriginRemote(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := parseAzureRemote(remote)
+		if err != nil {
+			return nil, err
+		}
+		api = azureItems{repo: repo, username: username, token: token}
+	case providerGitea:
+		remote, err := readOriginRemote(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		gitea := newGiteaContents(remote, token)
+		if apiURL != "" {
+			gitea.baseURL = apiURL
+		}
+		api = gitea
+	case providerHarness:
+		remote, err := readOriginRemote(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		// Without a token, use the clone token Harness CI passes to every step.
+		if token == "" {
+			token = os.Getenv("DRONE_NETRC_PASSWORD")
+		}
+		harness, err := newHarnessCode(remote, token)
+		if err != nil {
+			return nil, err
+		}
+		if apiURL != "" {
+			harness.baseURL = apiURL
+		}
+		api = harness
+	case providerCodeCommit:
+		raw, err := readOriginURL(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := parseCodeCommitRemote(raw)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := loadAWSCredentials()
+		if err != nil {
+			return nil, err
+		}
+		api = codecommitFiles{repo: repo, creds: creds}
+	default:
+		return nil, fmt.Errorf("unsupported provider %q", provider)
+	}
+	return &providerReader{name: provider, api: api, commits: make(map[string]string)}, nil
+}
+
+// knownHosts maps the hosts of the hosted providers to their provider.
+var knownHosts = map[string]string{
+	"github.com":        providerGitHub,
+	"ssh.github.com":    providerGitHub,
+	"gitlab.com":        providerGitLab,
+	"altssh.gitlab.com": providerGitLab,
+	"bitbucket.org":     providerBitbucket,
+	"dev.azure.com":     providerAzure,
+	"ssh.dev.azure.com": providerAzure,
+	"git.harness.io":    providerHarness,
+	"codeberg.org":      providerGitea,
+}
+
+// detectProvider picks the provider from the origin remote URL. Only the
+// known hosts of hosted providers are recognized, and in a GitLab CI job the
+// GitLab instance running it. Other hosts, such as self-hosted servers, use
+// git unless the provider is set explicitly.
+func detectProvider(raw string) string {
+	if _, err := parseCodeCommitRemote(raw); err == nil {
+		return providerCodeCommit
+	}
+	remote, err := parseRemoteURL(raw)
+	if err != nil {
+		return providerGit
+	}
+	host := strings.ToLower(remote.Host)
+	if provider, ok := knownHosts[host]; ok {
+		return provider
+	}
+	switch {
+	case strings.HasSuffix(host, ".visualstudio.com"):
+		return providerAzure
+	case host != "" && host == strings.ToLower(os.Getenv("CI_SERVER_HOST")):
+		return providerGitLab
+	}
+	return providerGit
+}
+
+// isGitHubHost reports whether a host is github.com.
+func isGitHubHost(host string) bool {
+	return detectProvider("https://"+host) == providerGitHub
+}
+
+// resolveProvider returns the provider to use and the git host credentials
+// are stored for: gitHost when set, otherwise the host of origin. With auto,
+// the provider is detected from origin, and its API is only used when
+// credentials for it are available.
+func resolveProvider(repoPath, provider, gitHost, token string) (string, string) {
+	host := "github.com"
+	raw, err := readOriginURL(repoPath)
+	if err == nil {
+		if remote, err := parseRemoteURL(raw); err == nil && remote.Host != "" {
+			host = remote.Host
+		}
+	}
+	if gitHost != "" {
+		host = gitHost
+	}
+	if provider != "" && provider != providerAuto {
+		return provider, host
+	}
+	if err != nil {
+		return providerGit, host
+	}
+	detected := detectProvider(raw)
+	if detected == providerGit {
+		return providerGit, host
+	}
+	if !hasProviderCredentials(detected, token) {
+		logrus.Infof("Detected %s from origin, but no credentials are set. Reading trusted files with git", detected)
+		return providerGit, host
+	}
+	logrus.Infof("Detected %s from origin", detected)
+	return detected, host
+}
+
+// selectProvider returns the configured provider or, in auto mode, the one
+// detected from origin regardless of credentials.
+func selectProvider(repoPath, provider string) string {
+	if provider != "" && provider != providerAuto {
+		return provider
+	}
+	raw, err := readOriginURL(repoPath)
+	if err != nil {
+		return providerGit
+	}
+	return detectProvider(raw)
+}
+
+// hasProviderCredentials reports whether the API of a provider can be
+// authenticated, with the token or credentials the provider falls back to.
+func hasProviderCredentials(provider, token string) bool {
+	switch {
+	case provider == providerCodeCommit:
+		_, err := loadAWSCredentials()
+		return err == nil
+	case token != "":
+		return true
+	case provider == providerGitLab:
+		return os.Getenv("CI_JOB_TOKEN") != ""
+	case provider == providerHarness:
+		return os.Getenv("DRONE_NETRC_PASSWORD") != ""
+	}
+	return false
+}
+
+// resolve returns the commit a trusted ref points to on the provider.
+func (r *providerReader) resolve(ref string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if commit, ok := r.commits[ref]; ok {
+		return commit, nil
+	}
+	if isCommitRef(ref) {
+		r.commits[ref] = ref
+		return ref, nil
+	}
+	commit, err := r.api.commit(strings.TrimPrefix(ref, tagRefPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s through the %s API: %w", describeTrustedRef(ref), r.name, err)
+	}
+	r.commits[ref] = commit
+	return commit, nil
+}
+
+// read reads a file from the trusted ref. It reports false when the file
+// does not exist.
+func (r *providerReader) read(ref, filePath string) (string, bool, error) {
+	commit, err := r.resolve(ref)
+	if err != nil {
+		return "", false, err
+	}
+	content, found, err := r.api.readFile(commit, filePath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s through the %s API: %w", filePath, r.name, err)
+	}
+	return content, found, nil
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Prefixes of fully qualified git refs.
+const (
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+)
+
+// commitSHA matches a full SHA-1 or SHA-256 commit ID.
+var commitSHA = regexp.MustCompile(`^(?:[0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// qualifyTrustedRef turns the trusted_ref setting into the ref used by the
+// rest of the plugin: branches as plain names and tags as refs/tags/<name>.
+// A plain name is a branch unless only a tag of that name exists. Full commit
+// SHAs are used as is.
+func qualifyTrustedRef(repoPath, ref string) string {
+	if isTagRef(ref) || isCommitRef(ref) {
+		return ref
+	}
+	if branch, ok := strings.CutPrefix(ref, branchRefPrefix); ok {
+		return branch
+	}
+	if showRef(repoPath, branchRefPrefix+ref) || showRef(repoPath, "refs/remotes/origin/"+ref) {
+		return ref
+	}
+	if showRef(repoPath, tagRefPrefix+ref) || remoteTagExists(repoPath, ref) {
+		return tagRefPrefix + ref
+	}
+	return ref
+}
+
+// isTagRef reports whether ref is a qualified tag ref.
+func isTagRef(ref string) bool {
+	return strings.HasPrefix(ref, tagRefPrefix)
+}
+
+// isCommitRef reports whether ref is a full commit SHA.
+func isCommitRef(ref string) bool {
+	return commitSHA.MatchString(ref)
+}
+
+// describeTrustedRef names the trusted ref in log and error messages.
+func describeTrustedRef(ref string) string {
+	if tag, ok := strings.CutPrefix(ref, tagRefPrefix); ok {
+		return fmt.Sprintf("trusted tag '%s'", tag)
+	}
+	if isCommitRef(ref) {
+		return fmt.Sprintf("trusted commit '%s'", ref)
+	}
+	return fmt.Sprintf("trusted branch '%s'", ref)
+}
+
+// describeTrustedRefs names one or more trusted refs in messages.
+func describeTrustedRefs(refs []string) string {
+	if len(refs) == 1 {
+		return describeTrustedRef(refs[0])
+	}
+	return fmt.Sprintf("trusted refs '%s'", strings.Join(refs, "', '"))
+}
+
+// fetchedRef returns the local ref holding the copy of ref fetched from origin.
+// Tags are fetched into the tag itself, branches into the remote-tracking
+// branch. Commits are addressed by their SHA.
+func fetchedRef(ref string) string {
+	if isTagRef(ref) || isCommitRef(ref) {
+		return ref
+	}
+	return "origin/" + ref
+}
+
+// fetchDepth is the number of commits fetched from the tip of a trusted ref
+// into a shallow workspace. Zero fetches the full history.
+var fetchDepth = 1
+
+// partialFetch leaves blobs out of fetches into a workspace that is a partial
+// clone, so only the files that are read are transferred. go-git always
+// fetches them.
+var partialFetch = true
+
+// fetchRef fetches the branch, tag or commit from the origin remote. Tags are
+// force updated, since the remote is the source of truth.
+func fetchRef(repoPath, ref string) error {
+	return withRetries("Fetching "+ref, func() error {
+		return gitOps.fetch(repoPath, ref, fetchDepth)
+	})
+}
+
+// readsTrustedHistory reports whether a check reads the history of the
+// trusted ref rather than its tip, e.g. the last author of a file, and so
+// needs it fetched in full.
+func readsTrustedHistory(args Args) bool {
+	return args.TrustedAuthors != "" || args.MaxAgeDays > 0 || args.AllowHistorical ||
+		args.RequireAncestor || args.MergeBase || args.SkipUntouched ||
+		args.RequireSigned || args.SSHAllowedSigners != "" || args.ApprovalTeam != ""
+}
+
+// showRef reports whether the fully qualified ref exists locally.
+func showRef(repoPath, ref string) bool {
+	return gitOps.hasRef(repoPath, ref)
+}
+
+// remoteTagExists reports whether origin has a tag with the given name.
+func remoteTagExists(repoPath, tag string) bool {
+	return gitOps.hasRemoteTag(repoPath, tag)
+}
+
+// trustedCommit returns the commit the trusted ref resolved to, preferring the
+// local ref the lightweight reads use over the fetched copy.
+func trustedCommit(repoPath, ref string) (string, error) {
+	if !refExists(repoPath, ref) {
+		ref = fetchedRef(ref)
+	}
+	return revParse(repoPath, ref+"^{commit}")
+}
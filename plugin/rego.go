@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/sirupsen/logrus"
+)
+
+// regoQuery is the rule a Rego policy defines: a set of messages explaining
+// why a file is denied. An empty or undefined set allows the file.
+const regoQuery = "data.trusted.deny"
+
+// regoInput is the document a Rego policy is evaluated against, once per file.
+type regoInput struct {
+	Path    string `json:"path"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+	// Contents and diff are only set in compare modes that read content.
+	CurrentContent string    `json:"current_content"`
+	TrustedContent string    `json:"trusted_content"`
+	Diff           regoDiff  `json:"diff"`
+	CurrentBranch  string    `json:"current_branch"`
+	TrustedRef     string    `json:"trusted_ref"`
+	Build          regoBuild `json:"build"`
+}
+
+// regoDiff lists the lines added and removed on the current branch.
+type regoDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// regoBuild is the build metadata exposed to the policy.
+type regoBuild struct {
+	Event        string `json:"event"`
+	Repo         string `json:"repo"`
+	Commit       string `json:"commit"`
+	Author       string `json:"author"`
+	TargetBranch string `json:"target_branch"`
+}
+
+// regoPolicy decides whether each compared file passes. The policy is
+// loaded from the trusted ref so it cannot be changed by the build it judges,
+// and is evaluated in process, so no opa binary is needed.
+type regoPolicy struct {
+	path  string
+	query rego.PreparedEvalQuery
+}
+
+// loadRegoPolicy reads a Rego policy from the trusted ref and compiles it.
+func loadRegoPolicy(repoPath, ref, policyPath string) (*regoPolicy, error) {
+	if _, err := resolveInRepo(repoPath, policyPath); err != nil {
+		return nil, err
+	}
+	policy, err := readTrustedFile(repoPath, ref, policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rego policy: %w", err)
+	}
+	query, err := rego.New(rego.Query(regoQuery), rego.Module(policyPath, policy)).PrepareForEval(commandCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile rego policy %s: %w", policyPath, err)
+	}
+	return &regoPolicy{path: policyPath, query: query}, nil
+}
+
+// apply evaluates the policy for every result. A denial fails the file; an
+// allowed file only passes despite a content difference, while failures of
+// other checks, such as signatures or hidden characters, are kept.
+func (p *regoPolicy) apply(plan *verificationPlan, results []fileResult, trustedContents []string, currentFiles map[string]currentFile) error {
+	build := regoBuild{
+		Event:        os.Getenv("DRONE_BUILD_EVENT"),
+		Repo:         os.Getenv("DRONE_REPO"),
+		Commit:       os.Getenv("DRONE_COMMIT_SHA"),
+		Author:       os.Getenv("DRONE_COMMIT_AUTHOR"),
+		TargetBranch: os.Getenv("DRONE_TARGET_BRANCH"),
+	}
+	for i := range results {
+		result := &results[i]
+		input := regoInput{
+			Path:           result.Path,
+			Matched:        result.Matched,
+			Reason:         result.Reason,
+			CurrentContent: currentFiles[result.Path].Content,
+			TrustedContent: trustedContents[i],
+			CurrentBranch:  plan.currentBranch,
+			TrustedRef:     plan.refFor(result.Path),
+			Build:          build,
+		}
+		if plan.options[result.Path].readsContent() {
+			input.Diff = diffLineLists(input.TrustedContent, input.CurrentContent)
+		}
+
+		denied, err := p.evaluate(input)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate rego policy %s for %s: %w", p.path, result.Path, err)
+		}
+		if len(denied) > 0 {
+			logrus.Warnf("Rego policy denies file %s: %s", result.Path, strings.Join(denied, "; "))
+			result.Matched = false
+			result.Reason = "denied by policy: " + strings.Join(denied, "; ")
+			continue
+		}
+		if result.Matched {
+			continue
+		}
+		if result.Reason != reasonContentDiffers {
+			logrus.Warnf("Rego policy allows file %s, but it still fails: %s", result.Path, result.Reason)
+			continue
+		}
+		logrus.Infof("Rego policy allows file %s despite: %s", result.Path, result.Reason)
+		result.Matched = true
+		result.Reason = ""
+	}
+	return nil
+}
+
+// evaluate evaluates the policy for a single input and returns the deny
+// messages.
+func (p *regoPolicy) evaluate(input regoInput) ([]string, error) {
+	// Round trip through JSON, so the policy sees the documented field names.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode input: %w", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to encode input: %w", err)
+	}
+	resultSet, err := p.query.Eval(commandCtx, rego.EvalInput(document))
+	if err != nil {
+		return nil, err
+	}
+	// An undefined deny rule produces no result at all.
+	if len(resultSet) == 0 || len(resultSet[0].Expressions) == 0 {
+		return nil, nil
+	}
+	denied, ok := resultSet[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a set of messages", regoQuery)
+	}
+	messages := make([]string, len(denied))
+	for i, d := range denied {
+		if s, ok := d.(string); ok {
+			messages[i] = s
+		} else {
+			messages[i] = fmt.Sprint(d)
+		}
+	}
+	return messages, nil
+}
+
+// diffLineLists splits the edit script between two texts into the lines
+// added and removed on the current side.
+func diffLineLists(trusted, current string) regoDiff {
+	diff := regoDiff{Added: []string{}, Removed: []string{}}
+	for _, op := range diffLines(splitLinesKeepEmpty(trusted), splitLinesKeepEmpty(current)) {
+		switch op.Kind {
+		case diffInsert:
+			diff.Added = append(diff.Added, op.Line)
+		case diffDelete:
+			diff.Removed = append(diff.Removed, op.Line)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// originRemote is the parsed URL of the origin remote.
+type originRemote struct {
+	// Host is the host name without port or credentials.
+	Host string
+	// Path is the repository path without a leading slash or .git suffix,
+	// e.g. owner/repo or group/subgroup/project.
+	Path string
+}
+
+// readOriginRemote parses the URL of the origin remote.
+func readOriginRemote(repoPath string) (originRemote, error) {
+	raw, err := readOriginURL(repoPath)
+	if err != nil {
+		return originRemote{}, err
+	}
+	return parseRemoteURL(raw)
+}
+
+// readOriginURL returns the URL of the origin remote as configured.
+func readOriginURL(repoPath string) (string, error) {
+	raw, err := gitOps.originURL(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the origin remote: %w", err)
+	}
+	return raw, nil
+}
+
+// parseRemoteURL parses HTTPS, SSH and scp-like (git@host:path) remote URLs.
+func parseRemoteURL(raw string) (originRemote, error) {
+	if !strings.Contains(raw, "://") {
+		// scp-like syntax: [user@]host:path
+		hostPart, path, ok := strings.Cut(raw, ":")
+		if !ok {
+			return originRemote{}, fmt.Errorf("unsupported remote URL")
+		}
+		if i := strings.LastIndex(hostPart, "@"); i >= 0 {
+			hostPart = hostPart[i+1:]
+		}
+		return originRemote{Host: hostPart, Path: trimRepoPath(path)}, nil
+	}
+	// The URL may embed credentials, so it is never included in errors.
+	u, err := url.Parse(raw)
+	if err != nil {
+		return originRemote{}, fmt.Errorf("unsupported remote URL")
+	}
+	return originRemote{Host: u.Hostname(), Path: trimRepoPath(u.Path)}, nil
+}
+
+func trimRepoPath(path string) string {
+	return strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resolveTrustedRefs resolves the trusted_ref setting, a list of refs in order
+// of precedence, or trusted_semver into qualified refs. Without either, pull
+// request builds use their target branch and other builds the default branch
+// of origin.
+func resolveTrustedRefs(repoPath, setting, semverConstraint string) ([]string, error) {
+	if semverConstraint != "" {
+		ref, err := resolveSemverTag(repoPath, semverConstraint)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ref}, nil
+	}
+
+	refs := splitList(setting)
+	if target := os.Getenv("DRONE_TARGET_BRANCH"); len(refs) == 0 && target != "" && os.Getenv("DRONE_BUILD_EVENT") == "pull_request" {
+		logrus.Infof("Using pull request target branch %s as the trusted branch", target)
+		refs = []string{target}
+	}
+	if len(refs) == 0 {
+		branch, err := defaultBranch(repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_ref is not set and the default branch could not be detected: %w", err)
+		}
+		refs = []string{branch}
+	}
+	var resolved []string
+	for _, ref := range refs {
+		if isGlob(ref) {
+			var err error
+			if ref, err = resolveBranchPattern(repoPath, ref); err != nil {
+				return nil, err
+			}
+		}
+		resolved = appendUnique(resolved, qualifyTrustedRef(repoPath, ref))
+	}
+	return resolved, nil
+}
+
+// exportTrustedRefs exports the trusted refs that were used and the commits
+// they resolved to, comma separated and in the same order. The commits are
+// returned for the attestation.
+func exportTrustedRefs(repoPath string, refs []string) ([]string, error) {
+	var commits []string
+	for _, ref := range refs {
+		// Files read through a provider API come from the commit it resolved.
+		var commit string
+		var err error
+		if trustedAPI != nil {
+			commit, err = trustedAPI.resolve(ref)
+		}
+		if trustedAPI == nil || err != nil {
+			commit, err = trustedCommit(repoPath, ref)
+		}
+		if err != nil {
+			logrus.Warnf("Failed to resolve the commit of %s: %v", describeTrustedRef(ref), err)
+			commit = ""
+		}
+		commits = append(commits, commit)
+	}
+	if err := WriteEnvToFile("TRUSTED_REF", strings.Join(refs, ",")); err != nil {
+		return nil, fmt.Errorf("failed to write TRUSTED_REF: %w", err)
+	}
+	if err := WriteEnvToFile("TRUSTED_COMMIT", strings.Join(commits, ",")); err != nil {
+		return nil, fmt.Errorf("failed to write TRUSTED_COMMIT: %w", err)
+	}
+	return commits, nil
+}
+
+// resolveBranchPattern resolves a trusted branch pattern such as release/* to
+// a single branch on origin. The target branch of a pull request wins when it
+// matches, otherwise the matching branch with the newest commit is used. On
+// other events the target branch is the branch that was pushed, so it is not
+// preferred.
+func resolveBranchPattern(repoPath, pattern string) (string, error) {
+	branches, err := listRemoteBranches(repoPath)
+	if err != nil {
+		return "", err
+	}
+	var matched []string
+	for _, branch := range branches {
+		if matchGlob(pattern, branch) {
+			matched = append(matched, branch)
+		}
+	}
+	if len(matched) == 0 {
+		return "", fmt.Errorf("trusted branch pattern %s matched no branches on origin", pattern)
+	}
+
+	if target := os.Getenv("DRONE_TARGET_BRANCH"); target != "" && os.Getenv("DRONE_BUILD_EVENT") == "pull_request" {
+		for _, branch := range matched {
+			if branch == target {
+				logrus.Infof("Trusted branch pattern %s resolved to target branch %s", pattern, branch)
+				return branch, nil
+			}
+		}
+	}
+
+	newest, newestTime := "", int64(-1)
+	for _, branch := range matched {
+		if err := fetchRef(repoPath, branch); err != nil {
+			return "", err
+		}
+		committed, err := commitTime(repoPath, fetchedRef(branch))
+		if err != nil {
+			return "", err
+		}
+		if committed > newestTime {
+			newest, newestTime = branch, committed
+		}
+	}
+	logrus.Infof("Trusted branch pattern %s resolved to %s, the newest of %d matching branches", pattern, newest, len(matched))
+	return newest, nil
+}
+
+// listRemoteBranches lists the branch names on origin.
+func listRemoteBranches(repoPath string) ([]string, error) {
+	var output []byte
+	err := withRetries("Listing branches on origin", func() (err error) {
+		output, err = commandOutput(command("git", "-C", repoPath, "ls-remote", "--heads", "origin"))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches on origin: %w", err)
+	}
+	var branches []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if _, ref, ok := strings.Cut(line, "\t"); ok {
+			branches = append(branches, strings.TrimPrefix(ref, branchRefPrefix))
+		}
+	}
+	return branches, nil
+}
+
+// commitTime returns the committer timestamp of ref.
+func commitTime(repoPath, ref string) (int64, error) {
+	cmd := command("git", "-C", repoPath, "log", "-1", "--format=%ct", ref)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read commit time of %s: %w", ref, err)
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+}
+
+// defaultBranch detects the default branch of origin, preferring the local
+// origin/HEAD symbolic ref and asking the remote otherwise.
+func defaultBranch(repoPath string) (string, error) {
+	branch, err := gitOps.defaultBranch(repoPath)
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("Using default branch %s of origin as the trusted branch", branch)
+	return branch, nil
+}
+
+// mergeBase returns the merge-base of HEAD and the trusted ref, so files are
+// compared against the trusted version the current branch was cut from.
+func mergeBase(repoPath, trustedRef string) (string, error) {
+	ref := trustedRef
+	if !refExists(repoPath, ref) {
+		if err := fetchRef(repoPath, ref); err != nil {
+			return "", err
+		}
+		ref = fetchedRef(ref)
+	}
+	cmd := command("git", "-C", repoPath, "merge-base", "HEAD", ref)
+	output, err := cmd.Output()
+	if err != nil {
+		if isShallowRepository(repoPath) {
+			return "", fmt.Errorf("failed to find the merge-base of HEAD and %s in a shallow clone, increase the clone depth: %w", ref, err)
+		}
+		return "", fmt.Errorf("failed to find the merge-base of HEAD and %s: %w", ref, err)
+	}
+	base := strings.TrimSpace(string(output))
+	logrus.Infof("Comparing against the merge-base %s of HEAD and %s", base, describeTrustedRef(trustedRef))
+	return base, nil
+}
+
+// isShallowRepository reports whether the repository is a shallow clone.
+func isShallowRepository(repoPath string) bool {
+	return gitOps.isShallow(repoPath)
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// gitTestEnv isolates the git commands of a test from the user's config.
+func gitTestEnv(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+}
+
+// runGit runs git in dir and fails the test when it fails.
+func runGit(t *testing.T, dir string, arg ...string) string {
+	t.Helper()
+	output, err := exec.Command("git", append([]string{"-C", dir}, arg...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", arg, err, output)
+	}
+	return string(output)
+}
+
+// commitAt commits everything in dir with the given commit date.
+func commitAt(t *testing.T, dir, message, date string) {
+	t.Helper()
+	t.Setenv("GIT_COMMITTER_DATE", date)
+	t.Setenv("GIT_AUTHOR_DATE", date)
+	runGit(t, dir, "commit", "--quiet", "--allow-empty", "-m", message)
+}
+
+func TestResolveBranchPattern(t *testing.T) {
+	gitTestEnv(t)
+	dir := t.TempDir()
+	origin, workspace := filepath.Join(dir, "origin"), filepath.Join(dir, "workspace")
+	runGit(t, dir, "init", "--quiet", "--initial-branch=main", origin)
+	commitAt(t, origin, "main", "2024-01-01T00:00:00Z")
+	runGit(t, origin, "checkout", "--quiet", "-b", "release/1.0")
+	commitAt(t, origin, "1.0", "2024-02-01T00:00:00Z")
+	runGit(t, origin, "checkout", "--quiet", "-b", "release/2.0", "main")
+	commitAt(t, origin, "2.0", "2024-03-01T00:00:00Z")
+	runGit(t, dir, "clone", "--quiet", origin, workspace)
+
+	tests := []struct {
+		event  string
+		target string
+		want   string
+	}{
+		{event: "pull_request", target: "release/1.0", want: "release/1.0"},
+		{event: "pull_request", target: "main", want: "release/2.0"},
+		// On a push the target is the pushed branch itself.
+		{event: "push", target: "release/1.0", want: "release/2.0"},
+		{event: "tag", target: "release/1.0", want: "release/2.0"},
+		{event: "", target: "", want: "release/2.0"},
+	}
+	for _, tt := range tests {
+		t.Setenv("DRONE_BUILD_EVENT", tt.event)
+		t.Setenv("DRONE_TARGET_BRANCH", tt.target)
+		got, err := resolveBranchPattern(workspace, "release/*")
+		if err != nil {
+			t.Fatalf("resolveBranchPattern failed on %q event: %v", tt.event, err)
+		}
+		if got != tt.want {
+			t.Errorf("resolveBranchPattern on %q event targeting %s = %s, want %s", tt.event, tt.target, got, tt.want)
+		}
+	}
+}
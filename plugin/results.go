@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons reported for files that did not match.
+const (
+	reasonContentDiffers = "content differs from trusted branch"
+	reasonAdded          = "added on current branch"
+	reasonRemoved        = "removed on current branch"
+	reasonDigestDiffers  = "sha256 differs from expected digest"
+	reasonHMACDiffers    = "hmac differs from expected value"
+)
+
+// fileResult is the verification outcome for a single file.
+type fileResult struct {
+	Path    string `json:"path"`
+	Ref     string `json:"ref,omitempty"`
+	Matched bool   `json:"matched"`
+	SHA256  string `json:"sha256,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// HistoricalCommit is the trusted commit matched when historical
+	// versions are allowed.
+	HistoricalCommit string `json:"historical_commit,omitempty"`
+	// ApprovedPullRequest is the pull request whose approvals let a
+	// differing file pass.
+	ApprovedPullRequest int `json:"approved_pull_request,omitempty"`
+
+	LinesAdded   int `json:"lines_added,omitempty"`
+	LinesRemoved int `json:"lines_removed,omitempty"`
+	Hunks        int `json:"hunks,omitempty"`
+}
+
+// sha256Hex returns the hex encoded SHA-256 digest of content.
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// sha256Reader returns the hex encoded SHA-256 digest of everything read from r.
+func sha256Reader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sha256File streams a file through SHA-256.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return sha256Reader(f)
+}
+
+// writeResults exports the per-file results as the TRUSTED_RESULTS JSON array.
+func writeResults(results []fileResult) error {
+	if results == nil {
+		results = []fileResult{}
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("failed to encode results: %w", err)
+	}
+	return WriteEnvToFile("TRUSTED_RESULTS", string(data))
+}
+
+// writeDiffStats exports the diff statistics summed over every mismatched file.
+func writeDiffStats(results []fileResult) error {
+	var added, removed, hunks int
+	for _, r := range results {
+		added += r.LinesAdded
+		removed += r.LinesRemoved
+		hunks += r.Hunks
+	}
+	if err := WriteEnvToFile("TRUSTED_DIFF_LINES_ADDED", strconv.Itoa(added)); err != nil {
+		return err
+	}
+	if err := WriteEnvToFile("TRUSTED_DIFF_LINES_REMOVED", strconv.Itoa(removed)); err != nil {
+		return err
+	}
+	return WriteEnvToFile("TRUSTED_DIFF_HUNKS", strconv.Itoa(hunks))
+}
+
+// reportResults exports the per-file results and returns an error naming every
+// file that did not match. against describes what the files were compared with.
+func reportResults(results []fileResult, currentBranch, against string) error {
+	if err := writeResults(results); err != nil {
+		return fmt.Errorf("failed to write TRUSTED_RESULTS: %w", err)
+	}
+
+	var mismatched []string
+	for _, result := range results {
+		if !result.Matched {
+			mismatched = append(mismatched, fmt.Sprintf("%s (%s)", result.Path, result.Reason))
+		}
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+	if err := writeDiffStats(results); err != nil {
+		return fmt.Errorf("failed to write diff statistics: %w", err)
+	}
+	return fmt.Errorf("file content mismatch between branch '%s' and %s: %s",
+		currentBranch, against, strings.Join(mismatched, ", "))
+}
+
+// reportChanges is the inverse of reportResults: it exports CHANGED and returns
+// an error naming every file that still matches what it was compared with.
+func reportChanges(results []fileResult, currentBranch, against string) error {
+	if err := writeResults(results); err != nil {
+		return fmt.Errorf("failed to write TRUSTED_RESULTS: %w", err)
+	}
+
+	var unchanged []string
+	for _, result := range results {
+		if result.Matched {
+			unchanged = append(unchanged, result.Path)
+		}
+	}
+	if err := WriteEnvToFile("CHANGED", strconv.FormatBool(len(unchanged) == 0)); err != nil {
+		return fmt.Errorf("failed to write CHANGED: %w", err)
+	}
+	if err := writeDiffStats(results); err != nil {
+		return fmt.Errorf("failed to write diff statistics: %w", err)
+	}
+	if len(unchanged) > 0 {
+		return fmt.Errorf("expected files on branch '%s' to differ from %s, but they are unchanged: %s",
+			currentBranch, against, strings.Join(unchanged, ", "))
+	}
+	logrus.Infof("All files differ from %s as expected.", against)
+	return nil
+}
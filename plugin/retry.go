@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxRetryWait caps the wait before a retry, including a Retry-After the
+// server asks for.
+const maxRetryWait = time.Minute
+
+var (
+	// retries is how often a failed remote operation is retried.
+	retries = 2
+	// retryBackoff is the wait before the first retry. It doubles with
+	// every further retry.
+	retryBackoff = time.Second
+)
+
+// permanentGitErrors mark git failures that retrying cannot fix.
+var permanentGitErrors = []string{
+	"Authentication failed",
+	"authentication required",
+	"could not read Username",
+	"terminal prompts disabled",
+	"Permission denied",
+	"not found",
+	"does not appear to be a git repository",
+	"couldn't find remote ref",
+	"not our ref",
+}
+
+// configureRetries sets the retries of git remote operations and API calls.
+// The API clients all use http.DefaultClient, whose transport retries
+// transient failures.
+func configureRetries(count int, backoff time.Duration) error {
+	if count < 0 {
+		return fmt.Errorf("invalid retries %d: expected 0 or more", count)
+	}
+	retries = count
+	if backoff > 0 {
+		retryBackoff = backoff
+	}
+	if count > 0 {
+		http.DefaultClient.Transport = retryTransport{base: http.DefaultTransport}
+	}
+	return nil
+}
+
+// retryDelay returns the wait before the given retry, counted from 1.
+func retryDelay(retry int) time.Duration {
+	delay := retryBackoff << (retry - 1)
+	if delay <= 0 || delay > maxRetryWait {
+		return maxRetryWait
+	}
+	return delay
+}
+
+// sleepForRetry waits before a retry, returning false when the run is
+// canceled first.
+func sleepForRetry(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-commandCtx.Done():
+		return false
+	}
+}
+
+// withRetries runs a git remote operation, retrying failures such as
+// network errors or a timed out fetch. Failures retrying cannot fix, such as
+// rejected credentials or a missing ref, are returned at once.
+func withRetries(operation string, op func() error) error {
+	err := op()
+	for retry := 1; err != nil && retry <= retries && transientGitError(err); retry++ {
+		delay := retryDelay(retry)
+		logrus.Warnf("%s failed, retrying in %s (%d/%d): %v", operation, delay, retry, retries, err)
+		if !sleepForRetry(delay) {
+			return err
+		}
+		err = op()
+	}
+	return err
+}
+
+// transientGitError reports whether retrying a failed git operation may help.
+func transientGitError(err error) bool {
+	if commandCtx.Err() != nil {
+		return false
+	}
+	message := err.Error()
+	for _, permanent := range permanentGitErrors {
+		if strings.Contains(message, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// retryTransport retries idempotent API requests that failed with a network
+// error, a rate limit or a gateway error. Other requests, such as a POST
+// creating a token, are sent once.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if !idempotentRequest(req) {
+		return resp, err
+	}
+	for retry := 1; retry <= retries && transientResponse(resp, err); retry++ {
+		delay := retryDelay(retry)
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			if after := retryAfter(resp); after > delay {
+				delay = min(after, maxRetryWait)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		logrus.Warnf("%s %s failed, retrying in %s (%d/%d): %s", req.Method, req.URL.Host+req.URL.Path, delay, retry, retries, reason)
+		if !sleepForRetry(delay) {
+			return nil, commandCtx.Err()
+		}
+		next := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if next.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.base.RoundTrip(next)
+	}
+	return resp, err
+}
+
+// idempotentRequest reports whether a request can safely be sent again: a
+// GET, HEAD or OPTIONS request, or one marked with an Idempotency-Key header,
+// whose body can be replayed.
+func idempotentRequest(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	_, hasKey := req.Header["Idempotency-Key"]
+	return hasKey
+}
+
+// transientResponse reports whether a request may succeed when sent again:
+// after a network error, a rate limit or a gateway error.
+func transientResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		// GitHub reports an exhausted rate limit as 403.
+		return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// retryAfter returns the wait a Retry-After header in seconds asks for.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaPolicy validates the trusted version of each file against a JSON
+// Schema. Files ending in .yaml or .yml are validated document by document,
+// everything else is parsed as JSON.
+type schemaPolicy struct {
+	schema *jsonschema.Schema
+}
+
+// newSchemaPolicy reads and compiles the JSON Schema from the trusted ref.
+func newSchemaPolicy(repoPath, ref, schemaPath string) (schemaPolicy, error) {
+	if _, err := resolveInRepo(repoPath, schemaPath); err != nil {
+		return schemaPolicy{}, err
+	}
+	content, err := readTrustedFile(repoPath, ref, schemaPath)
+	if err != nil {
+		return schemaPolicy{}, fmt.Errorf("failed to read json schema: %w", err)
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(schemaPath, strings.NewReader(content)); err != nil {
+		return schemaPolicy{}, fmt.Errorf("failed to load json schema %s: %w", schemaPath, err)
+	}
+	schema, err := compiler.Compile(schemaPath)
+	if err != nil {
+		return schemaPolicy{}, fmt.Errorf("failed to compile json schema %s: %w", schemaPath, err)
+	}
+	return schemaPolicy{schema: schema}, nil
+}
+
+func (p schemaPolicy) check(repoPath, ref, filePath string) (string, error) {
+	content, err := readTrustedFile(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	docs, err := schemaDocuments(filePath, content)
+	if err != nil {
+		return fmt.Sprintf("cannot be parsed for schema validation: %v", err), nil
+	}
+
+	var violations []string
+	for i, doc := range docs {
+		err := p.schema.Validate(doc)
+		var invalid *jsonschema.ValidationError
+		if errors.As(err, &invalid) {
+			prefix := ""
+			if len(docs) > 1 {
+				prefix = fmt.Sprintf("document %d ", i+1)
+			}
+			for _, leaf := range schemaViolations(invalid) {
+				violations = append(violations, prefix+leaf)
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to validate %s: %w", filePath, err)
+		}
+	}
+	if len(violations) == 0 {
+		return "", nil
+	}
+	return "violates the json schema: " + strings.Join(violations, "; "), nil
+}
+
+// schemaDocuments decodes the documents to validate. YAML documents are
+// round-tripped through JSON so they use the same types as JSON input.
+func schemaDocuments(filePath, content string) ([]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		yamlDocs, err := decodeYAML(content)
+		if err != nil {
+			return nil, err
+		}
+		docs := make([]interface{}, 0, len(yamlDocs))
+		for _, yamlDoc := range yamlDocs {
+			data, err := json.Marshal(yamlDoc)
+			if err != nil {
+				return nil, err
+			}
+			doc, err := decodeJSONNumbers(data)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+		return docs, nil
+	default:
+		doc, err := decodeJSONNumbers([]byte(content))
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{doc}, nil
+	}
+}
+
+// decodeJSONNumbers decodes a single JSON value keeping numbers exact.
+func decodeJSONNumbers(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// schemaViolations flattens a validation error into its leaf causes, each
+// prefixed with the JSON pointer of the offending value.
+func schemaViolations(invalid *jsonschema.ValidationError) []string {
+	if len(invalid.Causes) == 0 {
+		location := invalid.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{fmt.Sprintf("%s: %s", location, invalid.Message)}
+	}
+	var violations []string
+	for _, cause := range invalid.Causes {
+		violations = append(violations, schemaViolations(cause)...)
+	}
+	return violations
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions taken when the exported content contains secrets.
+const (
+	secretScanOff  = "off"
+	secretScanFail = "fail"
+	secretScanMask = "mask"
+)
+
+// secretMask replaces every secret in masked content.
+const secretMask = "[REDACTED]"
+
+// secretRule detects one kind of credential.
+type secretRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// secretRules are the built-in detectors. They favor well-known token formats
+// over entropy checks to keep false positives low.
+var secretRules = []secretRule{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"GitHub fine-grained token", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{82}\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+}
+
+// secretFinding is the location of a detected secret, never its value.
+type secretFinding struct {
+	rule  string
+	line  int
+	start int
+	end   int
+}
+
+// validateSecretScan checks the secret_scan setting.
+func validateSecretScan(action string) error {
+	switch action {
+	case secretScanOff, secretScanFail, secretScanMask:
+		return nil
+	default:
+		return fmt.Errorf("unsupported secret_scan %q, expected off, fail or mask", action)
+	}
+}
+
+// scanSecrets returns every secret found in content.
+func scanSecrets(content string) []secretFinding {
+	var findings []secretFinding
+	for _, rule := range secretRules {
+		for _, loc := range rule.re.FindAllStringIndex(content, -1) {
+			findings = append(findings, secretFinding{
+				rule:  rule.name,
+				line:  strings.Count(content[:loc[0]], "\n") + 1,
+				start: loc[0],
+				end:   loc[1],
+			})
+		}
+	}
+	return findings
+}
+
+// maskSecrets replaces every finding in content with secretMask. Overlapping
+// findings are merged.
+func maskSecrets(content string, findings []secretFinding) string {
+	covered := make([]bool, len(content))
+	for _, f := range findings {
+		for i := f.start; i < f.end; i++ {
+			covered[i] = true
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(content); i++ {
+		if !covered[i] {
+			b.WriteByte(content[i])
+			continue
+		}
+		b.WriteString(secretMask)
+		for i+1 < len(content) && covered[i+1] {
+			i++
+		}
+	}
+	return b.String()
+}
+
+// applySecretScan scans content that is about to be exported. It returns the
+// content to export, masked if requested, or an error when secrets must not
+// be exported.
+func applySecretScan(action, filePath, content string) (string, error) {
+	if action == secretScanOff {
+		return content, nil
+	}
+	findings := scanSecrets(content)
+	if len(findings) == 0 {
+		return content, nil
+	}
+	var found []string
+	for _, f := range findings {
+		logrus.Warnf("File %s contains a secret (%s) on line %d", filePath, f.rule, f.line)
+		found = append(found, fmt.Sprintf("%s on line %d", f.rule, f.line))
+	}
+	if action == secretScanMask {
+		logrus.Warnf("Masking %d secret(s) in the exported content of %s", len(findings), filePath)
+		return maskSecrets(content, findings), nil
+	}
+	return "", fmt.Errorf("refusing to export the content of %s, it contains secrets: %s", filePath, strings.Join(found, ", "))
+}
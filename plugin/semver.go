@@ -0,0 +1,300 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// semVersion is a parsed semantic version. Build metadata is ignored since it
+// does not affect precedence.
+type semVersion struct {
+	major, minor, patch int64
+	pre                 []string
+}
+
+// parseSemver parses a version such as v1.4.2 or 2.0.0-rc.1.
+func parseSemver(s string) (semVersion, bool) {
+	v, parts, ok := parsePartialSemver(s)
+	return v, ok && parts == 3
+}
+
+// parsePartialSemver parses a version that may omit the minor and patch
+// numbers, or use x or * for them, as in constraints like ~1.4 or 1.x. It
+// returns the number of components given.
+func parsePartialSemver(s string) (semVersion, int, bool) {
+	var v semVersion
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if pre == "" {
+			return v, 0, false
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 {
+		return v, 0, false
+	}
+	numbers := []*int64{&v.major, &v.minor, &v.patch}
+	parts := 0
+	for i, field := range fields {
+		if field == "x" || field == "X" || field == "*" {
+			break
+		}
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || n < 0 || (len(field) > 1 && field[0] == '0') {
+			return v, 0, false
+		}
+		*numbers[i] = n
+		parts++
+	}
+	if hasPre && parts != 3 {
+		return v, 0, false
+	}
+	return v, parts, true
+}
+
+// compare returns -1, 0 or 1 following semver precedence rules.
+func (v semVersion) compare(o semVersion) int {
+	for _, d := range []int64{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	// A version without a pre-release has higher precedence.
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, aErr := strconv.ParseInt(v.pre[i], 10, 64)
+		b, bErr := strconv.ParseInt(o.pre[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if a != b {
+				return sign(a - b)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(v.pre[i], o.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return sign(int64(len(v.pre) - len(o.pre)))
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// bump returns the smallest version above every version matching the first
+// parts components of v, e.g. 1.5.0 for 1.4 and 2.0.0 for 1.
+func (v semVersion) bump(parts int) semVersion {
+	switch parts {
+	case 0:
+		return semVersion{major: 1 << 62}
+	case 1:
+		return semVersion{major: v.major + 1}
+	case 2:
+		return semVersion{major: v.major, minor: v.minor + 1}
+	}
+	return semVersion{major: v.major, minor: v.minor, patch: v.patch + 1}
+}
+
+// semverBound is a single half-open comparison against a version.
+type semverBound struct {
+	op string
+	v  semVersion
+}
+
+func (b semverBound) matches(v semVersion) bool {
+	c := v.compare(b.v)
+	switch b.op {
+	case ">=":
+		return c >= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return c == 0
+}
+
+// semverConstraint is a list of alternatives separated by ||, each a list of
+// bounds that must all match.
+type semverConstraint [][]semverBound
+
+// parseSemverConstraint parses constraints such as "*", "~1.4", "^2.1.0" or
+// ">=1.2, <2 || 3.x".
+func parseSemverConstraint(s string) (semverConstraint, error) {
+	var constraint semverConstraint
+	for _, alternative := range strings.Split(s, "||") {
+		var bounds []semverBound
+		terms := strings.FieldsFunc(alternative, func(r rune) bool { return r == ',' || r == ' ' })
+		for i := 0; i < len(terms); i++ {
+			term := terms[i]
+			// An operator may be separated from its version, as in ">= 1.2".
+			if isSemverOperator(term) && i+1 < len(terms) {
+				i++
+				term += terms[i]
+			}
+			termBounds, err := parseSemverTerm(term)
+			if err != nil {
+				return nil, fmt.Errorf("invalid semver constraint %q: %w", s, err)
+			}
+			bounds = append(bounds, termBounds...)
+		}
+		constraint = append(constraint, bounds)
+	}
+	return constraint, nil
+}
+
+// semverOperators are the operators a constraint term may start with,
+// longest first.
+var semverOperators = []string{">=", "<=", ">", "<", "=", "~", "^"}
+
+// isSemverOperator reports whether a term is an operator without a version.
+func isSemverOperator(term string) bool {
+	for _, op := range semverOperators {
+		if term == op {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSemverTerm expands a single term into lower and upper bounds.
+func parseSemverTerm(term string) ([]semverBound, error) {
+	op := ""
+	for _, prefix := range semverOperators {
+		if strings.HasPrefix(term, prefix) {
+			op, term = prefix, strings.TrimPrefix(term, prefix)
+			break
+		}
+	}
+	v, parts, ok := parsePartialSemver(term)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a version", term)
+	}
+	if parts == 0 && op != "" && op != "=" {
+		return nil, fmt.Errorf("%q needs a version", op)
+	}
+
+	switch op {
+	case "", "=":
+		if parts == 3 {
+			return []semverBound{{"=", v}}, nil
+		}
+		if parts == 0 {
+			return nil, nil
+		}
+		return []semverBound{{">=", v}, {"<", v.bump(parts)}}, nil
+	case "~":
+		return []semverBound{{">=", v}, {"<", v.bump(min(parts, 2))}}, nil
+	case "^":
+		upper := parts
+		switch {
+		case v.major > 0 || parts == 1:
+			upper = 1
+		case v.minor > 0 || parts == 2:
+			upper = 2
+		}
+		return []semverBound{{">=", v}, {"<", v.bump(upper)}}, nil
+	case ">":
+		if parts < 3 {
+			return []semverBound{{">=", v.bump(parts)}}, nil
+		}
+	case "<=":
+		if parts < 3 {
+			return []semverBound{{"<", v.bump(parts)}}, nil
+		}
+	}
+	return []semverBound{{op, v}}, nil
+}
+
+// matches reports whether v satisfies the constraint.
+func (c semverConstraint) matches(v semVersion) bool {
+	for _, bounds := range c {
+		matched := true
+		for _, b := range bounds {
+			if !b.matches(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSemverTag returns the highest release tag on origin satisfying the
+// constraint. Pre-release tags are skipped.
+func resolveSemverTag(repoPath, constraint string) (string, error) {
+	c, err := parseSemverConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	tags, err := listRemoteTags(repoPath)
+	if err != nil {
+		return "", err
+	}
+
+	best, found := "", false
+	var bestVersion semVersion
+	for _, tag := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || len(v.pre) > 0 || !c.matches(v) {
+			continue
+		}
+		if !found || v.compare(bestVersion) > 0 {
+			best, bestVersion, found = tag, v, true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no release tag on origin satisfies %s", constraint)
+	}
+	logrus.Infof("Trusted semver constraint %s resolved to tag %s", constraint, best)
+	return tagRefPrefix + best, nil
+}
+
+// listRemoteTags lists the tag names on origin.
+func listRemoteTags(repoPath string) ([]string, error) {
+	var output []byte
+	err := withRetries("Listing tags on origin", func() (err error) {
+		output, err = commandOutput(command("git", "-C", repoPath, "ls-remote", "--tags", "--refs", "origin"))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags on origin: %w", err)
+	}
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if _, ref, ok := strings.Cut(line, "\t"); ok {
+			tags = append(tags, strings.TrimPrefix(ref, tagRefPrefix))
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSemverConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{constraint: "*", match: []string{"0.0.1", "1.0.0", "12.3.4"}},
+		{constraint: "1.x", match: []string{"1.0.0", "1.9.9"}, noMatch: []string{"0.9.9", "2.0.0"}},
+		{constraint: "1.2", match: []string{"1.2.0", "1.2.9"}, noMatch: []string{"1.1.9", "1.3.0"}},
+		{constraint: "v1.2.3", match: []string{"1.2.3"}, noMatch: []string{"1.2.4"}},
+		{constraint: "= 1.2.3", match: []string{"1.2.3"}, noMatch: []string{"1.2.2", "1.2.4"}},
+		{constraint: "~1.4", match: []string{"1.4.0", "1.4.9"}, noMatch: []string{"1.3.9", "1.5.0"}},
+		{constraint: "~1.4.2", match: []string{"1.4.2", "1.4.10"}, noMatch: []string{"1.4.1", "1.5.0"}},
+		{constraint: "^2.1.0", match: []string{"2.1.0", "2.9.9"}, noMatch: []string{"2.0.9", "3.0.0"}},
+		{constraint: "^0.2.3", match: []string{"0.2.3", "0.2.9"}, noMatch: []string{"0.2.2", "0.3.0"}},
+		{constraint: "^0.0.3", match: []string{"0.0.3"}, noMatch: []string{"0.0.4"}},
+		{constraint: ">=1.2", match: []string{"1.2.0", "5.0.0"}, noMatch: []string{"1.1.9"}},
+		{constraint: ">= 1.2", match: []string{"1.2.0", "5.0.0"}, noMatch: []string{"1.1.9"}},
+		{constraint: ">= 1.2 < 2", match: []string{"1.2.0", "1.9.9"}, noMatch: []string{"1.1.9", "2.0.0"}},
+		{constraint: ">= 1.2, < 2", match: []string{"1.5.0"}, noMatch: []string{"2.0.0"}},
+		{constraint: "> 1.2", match: []string{"1.3.0"}, noMatch: []string{"1.2.9"}},
+		{constraint: ">1.2.3", match: []string{"1.2.4"}, noMatch: []string{"1.2.3"}},
+		{constraint: "<= 1.2", match: []string{"1.2.9"}, noMatch: []string{"1.3.0"}},
+		{constraint: ">=1.2, <2 || 3.x", match: []string{"1.2.0", "1.9.9", "3.4.5"}, noMatch: []string{"1.1.9", "2.0.0", "4.0.0"}},
+		{constraint: ">= 1.2 <2 || >= 3", match: []string{"1.2.0", "3.0.0"}, noMatch: []string{"2.5.0"}},
+	}
+	for _, tt := range tests {
+		c, err := parseSemverConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("parseSemverConstraint(%q) failed: %v", tt.constraint, err)
+			continue
+		}
+		for _, version := range append(tt.match, tt.noMatch...) {
+			v, ok := parseSemver(version)
+			if !ok {
+				t.Fatalf("parseSemver(%q) failed", version)
+			}
+			want := !slices.Contains(tt.noMatch, version)
+			if got := c.matches(v); got != want {
+				t.Errorf("%q matches %s = %v, want %v", tt.constraint, version, got, want)
+			}
+		}
+	}
+}
+
+func TestSemverConstraintInvalid(t *testing.T) {
+	for _, constraint := range []string{"foo", ">=", ">= ", "~", ">= 1.2 <", "1.2.3.4", "01.2", "1.2.3-", "1.2-rc.1", ">= >= 1.2"} {
+		if _, err := parseSemverConstraint(constraint); err == nil {
+			t.Errorf("parseSemverConstraint(%q) succeeded, want an error", constraint)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	// Ordered by precedence as in the semver specification.
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0+build.1",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, aOK := parseSemver(ordered[i])
+			b, bOK := parseSemver(ordered[j])
+			if !aOK || !bOK {
+				t.Fatalf("failed to parse %q or %q", ordered[i], ordered[j])
+			}
+			if got, want := a.compare(b), sign(int64(i-j)); got != want {
+				t.Errorf("compare(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
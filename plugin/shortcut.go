@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// onTrustedRef reports whether the build already runs on the trusted ref,
+// either by branch name or because HEAD is the trusted commit, and the files
+// to verify are unmodified in the workspace. Comparing is pointless then.
+// Pull request builds never take this shortcut.
+func onTrustedRef(plan *verificationPlan) bool {
+	if len(plan.trustedRefs) != 1 || os.Getenv("DRONE_BUILD_EVENT") == "pull_request" {
+		return false
+	}
+	ref := plan.trustedBranch
+	if plan.currentBranch != ref && !headIsTrustedCommit(plan.repoPath, ref) {
+		return false
+	}
+
+	if !gitOps.unmodified(plan.repoPath, plan.files) {
+		logrus.Infof("Build runs on %s but the workspace has local changes, verifying anyway", describeTrustedRef(ref))
+		return false
+	}
+	return true
+}
+
+// headIsTrustedCommit reports whether HEAD is the commit the trusted ref
+// points to locally, without fetching.
+func headIsTrustedCommit(repoPath, ref string) bool {
+	if !refExists(repoPath, ref) {
+		ref = fetchedRef(ref)
+	}
+	trusted, err := revParse(repoPath, ref+"^{commit}")
+	if err != nil {
+		return false
+	}
+	head, err := revParse(repoPath, "HEAD")
+	return err == nil && head == trusted
+}
+
+// trustedShortcutResults marks every scheduled file as matching, for builds
+// running on the trusted ref.
+func trustedShortcutResults(plan *verificationPlan, currentFiles map[string]currentFile) ([]fileResult, []string) {
+	results := make([]fileResult, len(plan.files))
+	contents := make([]string, len(plan.files))
+	for i, filePath := range plan.files {
+		opts := plan.options[filePath]
+		results[i] = fileResult{Path: filePath, Matched: true}
+		if opts.readsContent() {
+			results[i].SHA256 = sha256Hex(currentFiles[filePath].Content)
+		} else if opts.Mode == compareModeSHA256 {
+			results[i].SHA256 = currentFiles[filePath].SHA256
+		}
+		contents[i] = currentFiles[filePath].Content
+	}
+	return results, contents
+}
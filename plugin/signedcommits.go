@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// signedCommitsPolicy requires every commit touching a file on the trusted ref
+// to carry a good GPG signature from a key in the keyring.
+type signedCommitsPolicy struct {
+	keyring *gpgKeyring
+	since   string
+}
+
+func (p signedCommitsPolicy) check(repoPath, ref, filePath string) (string, error) {
+	resolved, err := resolveTrustedRef(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"-C", repoPath, "log", "--format=%H %G?"}
+	if p.since != "" {
+		args = append(args, "--since="+p.since)
+	}
+	args = append(args, resolved, "--", filePath)
+	cmd := command("git", args...)
+	p.keyring.setEnv(cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to verify the commit signatures of %s on %s: %w", filePath, resolved, err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		commit, status, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		// G is a good signature and U a good signature from a key without
+		// owner trust, which is expected for a keyring built on the fly.
+		if status != "G" && status != "U" {
+			return fmt.Sprintf("trusted commit %s touching the file is not signed by a trusted key (%s)", commit, describeSignatureStatus(status)), nil
+		}
+	}
+	return "", nil
+}
+
+// describeSignatureStatus explains a git %G? signature status.
+func describeSignatureStatus(status string) string {
+	switch status {
+	case "N":
+		return "unsigned"
+	case "B":
+		return "bad signature"
+	case "X", "Y":
+		return "expired signature or key"
+	case "R":
+		return "revoked key"
+	case "E":
+		return "unknown key"
+	case "U":
+		return "key not trusted"
+	default:
+		return "signature status " + status
+	}
+}
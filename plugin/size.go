@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions taken when a file exceeds the maximum size.
+const (
+	sizeActionFail = "fail"
+	sizeActionHash = "hash"
+)
+
+// sizeLimit guards against loading huge files into memory.
+type sizeLimit struct {
+	max    int64
+	action string
+}
+
+// newSizeLimit parses the max_file_size settings. A zero max disables the guard.
+func newSizeLimit(maxSize, action string) (sizeLimit, error) {
+	limitBytes, err := parseSize(maxSize)
+	if err != nil {
+		return sizeLimit{}, fmt.Errorf("invalid max_file_size: %w", err)
+	}
+	switch action {
+	case sizeActionFail, sizeActionHash:
+	default:
+		return sizeLimit{}, fmt.Errorf("unsupported max_file_size_action %q, expected fail or hash", action)
+	}
+	return sizeLimit{max: limitBytes, action: action}, nil
+}
+
+// enforce checks both sides of a file that is about to be read into memory.
+// Oversized files either fail verification or switch to sha256 comparison.
+func (l sizeLimit) enforce(plan *verificationPlan, filePath string, currentSize int64) error {
+	opts := plan.options[filePath]
+	if l.max == 0 || !opts.readsContent() {
+		return nil
+	}
+
+	size, side := currentSize, "current branch"
+	if size <= l.max {
+		trustedSize, err := trustedFileSize(plan.repoPath, plan.refFor(filePath), filePath)
+		if err != nil {
+			// A missing trusted file is reported when it is compared.
+			return nil
+		}
+		size, side = trustedSize, "trusted branch"
+	}
+	if size <= l.max {
+		return nil
+	}
+
+	if l.action == sizeActionFail {
+		return fmt.Errorf("file %s on the %s is %d bytes, exceeding max_file_size of %d bytes", filePath, side, size, l.max)
+	}
+	logrus.Warnf("File %s on the %s is %d bytes, exceeding max_file_size; comparing SHA-256 digests instead", filePath, side, size)
+	plan.options[filePath] = compareOptions{Mode: compareModeSHA256, Variants: opts.Variants}
+	return nil
+}
+
+// trustedFileSize returns the size of a file on the trusted branch without reading it.
+func trustedFileSize(repoPath, branch, filePath string) (int64, error) {
+	ref, err := resolveTrustedRef(repoPath, branch, filePath)
+	if err != nil {
+		return 0, err
+	}
+	return gitOps.fileSize(repoPath, ref, filePath)
+}
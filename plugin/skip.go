@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// buildBase returns the commit the changes of this build are measured from:
+// the merge-base of HEAD and the trusted ref, when that ref is available
+// without fetching. DRONE_COMMIT_BEFORE is not used, since it only covers the
+// last push: a protected file changed in an earlier push of the same branch
+// would go unverified.
+func buildBase(repoPath, trustedRef string) string {
+	ref := trustedRef
+	if !refExists(repoPath, ref) {
+		ref = fetchedRef(ref)
+	}
+	output, err := command("git", "-C", repoPath, "merge-base", "HEAD", ref).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// pathsUntouched reports whether none of the pathspecs differ between base
+// and the workspace, which covers both committed and uncommitted changes.
+func pathsUntouched(repoPath, base string, pathspecs []string) (bool, error) {
+	args := append([]string{"-C", repoPath, "diff", "--quiet", base, "--"}, pathspecs...)
+	err := command("git", args...).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// untouchedInBuild reports whether the build left every protected path
+// untouched, so verification can be skipped. Manifests list their files on
+// the trusted branch, so builds using one are never skipped.
+func untouchedInBuild(repoPath, trustedRef string, args Args) bool {
+	if args.ManifestPath != "" {
+		logrus.Info("skip_untouched is not supported with manifest_path, verifying")
+		return false
+	}
+	base := buildBase(repoPath, trustedRef)
+	if base == "" {
+		logrus.Info("Could not determine the commit range of this build, verifying")
+		return false
+	}
+
+	var pathspecs []string
+	for _, p := range collectFilePaths(args) {
+		if isGlob(p) {
+			p = ":(glob)" + p
+		}
+		pathspecs = append(pathspecs, p)
+	}
+	if args.DirPath != "" {
+		pathspecs = append(pathspecs, args.DirPath)
+	}
+	untouched, err := pathsUntouched(repoPath, base, pathspecs)
+	if err != nil {
+		logrus.Warnf("Failed to check for changes since %s: %v", base, err)
+		return false
+	}
+	if untouched {
+		logrus.Infof("Protected files are untouched since %s", base)
+	}
+	return untouched
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// decryptSOPS decrypts a SOPS encrypted file with the sops binary. The
+// content is written to a temporary file with the same extension as
+// filePath, since sops picks the file format from the extension. Keys come
+// from the environment, e.g. SOPS_AGE_KEY or the ambient cloud credentials
+// for KMS.
+func decryptSOPS(filePath, content string) (string, error) {
+	tmp, err := os.CreateTemp("", "trusted-*"+filepath.Ext(filePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := command("sops", "--decrypt", tmp.Name())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to decrypt %s with sops: %w", filePath, commandError(err, stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// compareSOPS decrypts both sides and compares the plaintext, so a file that
+// was re-encrypted with the same values still matches.
+func compareSOPS(filePath string, opts compareOptions, trusted, current string) (bool, error) {
+	trustedPlain, err := decryptSOPS(filePath, trusted)
+	if err != nil {
+		return false, fmt.Errorf("trusted version: %w", err)
+	}
+	currentPlain, err := decryptSOPS(filePath, current)
+	if err != nil {
+		return false, fmt.Errorf("current version: %w", err)
+	}
+	return compareContent(filePath, opts, trustedPlain, currentPlain)
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configureSSHKey writes the SSH private key, and the known hosts when set,
+// to a private temporary directory and points GIT_SSH_COMMAND at them, so git
+// can fetch from SSH remotes. The returned function removes them again.
+func configureSSHKey(key, knownHosts string) (func(), error) {
+	dir, err := os.MkdirTemp("", "trusted-ssh-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	// ssh rejects keys that are readable by others or lack a final newline.
+	keyFile := filepath.Join(dir, "id")
+	if err := os.WriteFile(keyFile, []byte(strings.TrimSpace(key)+"\n"), 0600); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to write ssh_key: %w", err)
+	}
+	command := []string{"ssh", "-i", shellQuote(keyFile), "-o", "IdentitiesOnly=yes"}
+	if knownHosts != "" {
+		knownHostsFile := filepath.Join(dir, "known_hosts")
+		if err := os.WriteFile(knownHostsFile, []byte(strings.TrimSpace(knownHosts)+"\n"), 0600); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to write ssh_known_hosts: %w", err)
+		}
+		command = append(command, "-o", shellQuote("UserKnownHostsFile="+knownHostsFile), "-o", "StrictHostKeyChecking=yes")
+	} else {
+		// Without known hosts, trust the host key on first use.
+		command = append(command, "-o", "StrictHostKeyChecking=accept-new")
+	}
+	if err := os.Setenv("GIT_SSH_COMMAND", strings.Join(command, " ")); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to configure GIT_SSH_COMMAND: %w", err)
+	}
+	return cleanup, nil
+}
+
+// shellQuote quotes a word for the shell git runs GIT_SSH_COMMAND with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sshSignaturePolicy requires the commit that last modified a file on the
+// trusted ref to carry a good SSH signature (gpg.format=ssh) from a key in
+// the allowed signers file.
+type sshSignaturePolicy struct {
+	allowedSigners string
+}
+
+// newSSHSignaturePolicy writes the allowed signers to a temporary file for git.
+func newSSHSignaturePolicy(allowedSigners string) (*sshSignaturePolicy, error) {
+	f, err := os.CreateTemp("", "trusted-allowed-signers-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create allowed signers file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(allowedSigners); err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to write allowed signers file: %w", err)
+	}
+	return &sshSignaturePolicy{allowedSigners: f.Name()}, nil
+}
+
+func (p *sshSignaturePolicy) check(repoPath, ref, filePath string) (string, error) {
+	resolved, err := resolveTrustedRef(repoPath, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	cmd := command("git", "-C", repoPath, "-c", "gpg.ssh.allowedSignersFile="+p.allowedSigners,
+		"log", "-1", "--format=%H %G?", resolved, "--", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to verify the commit signature of %s on %s: %w", filePath, resolved, err)
+	}
+	commit, status, _ := strings.Cut(strings.TrimSpace(string(output)), " ")
+	if status != "G" {
+		return fmt.Sprintf("trusted commit %s that last modified the file is not SSH signed by an allowed signer (%s)", commit, describeSignatureStatus(status)), nil
+	}
+	return "", nil
+}
+
+// Close removes the allowed signers file.
+func (p *sshSignaturePolicy) Close() error {
+	return os.Remove(p.allowedSigners)
+}
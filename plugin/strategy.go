@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Sources trusted files are read from.
+const (
+	// sourceAPI reads through the provider API.
+	sourceAPI = "api"
+	// sourceLocal reads the trusted ref already present in the workspace.
+	sourceLocal = "local"
+	// sourceFetch fetches the trusted ref from origin, checking it out when
+	// a file cannot be read otherwise.
+	sourceFetch = "fetch"
+)
+
+// strategies maps the named retrieval strategies to their sources, in the
+// order they are tried.
+var strategies = map[string][]string{
+	"api-first":   {sourceAPI, sourceLocal, sourceFetch},
+	"git-first":   {sourceLocal, sourceFetch, sourceAPI},
+	"fetch-first": {sourceFetch, sourceAPI},
+	"api-only":    {sourceAPI},
+	"git-only":    {sourceLocal, sourceFetch},
+	"local-only":  {sourceLocal},
+}
+
+// retrievalSources is the order trusted files are read in. Later sources are
+// only tried when the earlier ones fail.
+var retrievalSources = strategies["git-only"]
+
+// parseStrategy parses the strategy setting: a named strategy, or a comma
+// separated list of the sources api, local and fetch in the order to try
+// them. A single source disables falling back. Without a strategy, trusted
+// files are read with git, or first through the provider API when one was
+// selected explicitly.
+func parseStrategy(strategy string, apiSelected bool) ([]string, error) {
+	if strategy == "" {
+		if apiSelected {
+			return strategies["api-first"], nil
+		}
+		return strategies["git-only"], nil
+	}
+	if sources, ok := strategies[strategy]; ok {
+		return sources, nil
+	}
+	var sources []string
+	for _, source := range splitList(strategy) {
+		switch source {
+		case sourceAPI, sourceLocal, sourceFetch:
+		default:
+			return nil, fmt.Errorf("invalid strategy %q: expected api-first, git-first, fetch-first, api-only, git-only, local-only or a list of api, local and fetch", strategy)
+		}
+		if slices.Contains(sources, source) {
+			return nil, fmt.Errorf("invalid strategy %q: %s is listed twice", strategy, source)
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("invalid strategy %q", strategy)
+	}
+	return sources, nil
+}
+
+// allowsSource reports whether the strategy reads from a source.
+func allowsSource(source string) bool {
+	return slices.Contains(retrievalSources, source)
+}
+
+// describeSource names a source in log messages.
+func describeSource(source string) string {
+	switch source {
+	case sourceAPI:
+		if trustedAPI != nil {
+			return fmt.Sprintf("the %s API", trustedAPI.name)
+		}
+		return "the provider API"
+	case sourceLocal:
+		return "the local ref"
+	default:
+		return "a heavyweight checkout"
+	}
+}
+
+// usableSources returns the sources of the strategy that can be used, which
+// excludes the provider API when no provider is configured.
+func usableSources() []string {
+	var sources []string
+	for _, source := range retrievalSources {
+		if source != sourceAPI || trustedAPI != nil {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// compareYAML parses both sides as (possibly multi-document) YAML and compares
+// the resulting structures, ignoring key order, quoting style and comments.
+// A current file that fails to parse is reported as a mismatch.
+func compareYAML(filePath, trusted, current string) (bool, error) {
+	trustedDocs, err := decodeYAML(trusted)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse trusted YAML: %w", err)
+	}
+	currentDocs, err := decodeYAML(current)
+	if err != nil {
+		logrus.Warnf("File %s is not valid YAML on the current branch: %v", filePath, err)
+		return false, nil
+	}
+	return reflect.DeepEqual(trustedDocs, currentDocs), nil
+}
+
+// decodeYAML decodes every document in content.
+func decodeYAML(content string) ([]interface{}, error) {
+	var docs []interface{}
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// compareJSON canonicalizes both sides as JSON (key order ignored, numbers
+// normalized) and compares the results. A current file that fails to parse is
+// reported as a mismatch.
+func compareJSON(filePath, trusted, current string) (bool, error) {
+	trustedValue, err := canonicalJSON(trusted)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse trusted JSON: %w", err)
+	}
+	currentValue, err := canonicalJSON(current)
+	if err != nil {
+		logrus.Warnf("File %s is not valid JSON on the current branch: %v", filePath, err)
+		return false, nil
+	}
+	return reflect.DeepEqual(trustedValue, currentValue), nil
+}
+
+// canonicalNumber is a JSON number in exact rational form, so 1, 1.0 and 1e0
+// are equal. It is a distinct type so it never equals a JSON string.
+type canonicalNumber string
+
+// canonicalJSON decodes a single JSON value with every number canonicalized.
+func canonicalJSON(content string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unexpected data after the top-level value")
+	}
+	return canonicalizeNumbers(value)
+}
+
+// canonicalizeNumbers replaces every json.Number with a canonicalNumber.
+func canonicalizeNumbers(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		r, ok := new(big.Rat).SetString(v.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		return canonicalNumber(r.RatString()), nil
+	case map[string]interface{}:
+		for key, item := range v {
+			normalized, err := canonicalizeNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalized
+		}
+	case []interface{}:
+		for i, item := range v {
+			normalized, err := canonicalizeNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+	}
+	return value, nil
+}
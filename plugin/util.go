@@ -1,12 +1,63 @@
 package plugin
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// outputMu serializes the writes to the output files with closing them.
+	outputMu sync.Mutex
+	// outputsClosed is set once TRUSTED was written, by the end of the run
+	// or by the timeout. No outputs are written after it.
+	outputsClosed bool
 )
 
+// errOutputsClosed is returned for outputs written after TRUSTED.
+var errOutputsClosed = errors.New("outputs were already closed, the run ended or timed out")
+
+// openOutputs allows writing the outputs of a new run.
+func openOutputs() {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	outputsClosed = false
+}
+
+// closeOutputs writes TRUSTED as the last output of the run. Only the first
+// call writes it, so once the timeout closed the outputs a finishing run
+// cannot report success, and once the run closed them the timeout has
+// nothing left to stop. It reports whether this call closed them.
+func closeOutputs(trusted string) bool {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if outputsClosed {
+		return false
+	}
+	outputsClosed = true
+	if err := writeEnv("TRUSTED", trusted); err != nil {
+		logrus.Warnf("Failed to write TRUSTED variable: %v", err)
+	}
+	return true
+}
+
 // WriteEnvToFile writes a key=value pair to the output file defined by the DRONE_OUTPUT environment variable.
 func WriteEnvToFile(key, value string) error {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if outputsClosed {
+		return fmt.Errorf("failed to write %s: %w", key, errOutputsClosed)
+	}
+	return writeEnv(key, value)
+}
+
+// writeEnv appends a key=value pair to the output file.
+func writeEnv(key, value string) error {
 	outputFile, err := os.OpenFile(os.Getenv("DRONE_OUTPUT"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open output file: %w", err)
@@ -20,3 +71,92 @@ func WriteEnvToFile(key, value string) error {
 
 	return nil
 }
+
+// WriteSecretEnvToFile writes a key=value pair to the secret output file
+// defined by the HARNESS_OUTPUT_SECRET_FILE environment variable.
+func WriteSecretEnvToFile(key, value string) error {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if outputsClosed {
+		return fmt.Errorf("failed to write %s: %w", key, errOutputsClosed)
+	}
+	outputFile, err := os.OpenFile(os.Getenv("HARNESS_OUTPUT_SECRET_FILE"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open secret output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	if _, err := fmt.Fprintf(outputFile, "%s=%s\n", key, value); err != nil {
+		return fmt.Errorf("failed to write to secret env: %w", err)
+	}
+	return nil
+}
+
+// splitList splits a comma or newline separated setting into trimmed, non-empty entries.
+func splitList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	var items []string
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			items = append(items, field)
+		}
+	}
+	return items
+}
+
+// appendUnique appends the items that are not already present in list.
+func appendUnique(list []string, items ...string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		seen[item] = true
+	}
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitLines splits a newline separated setting into trimmed, non-empty
+// entries. It is used for values such as regular expressions that may contain commas.
+func splitLines(value string) []string {
+	var items []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
+
+// parseSize parses a byte size such as "1048576", "512K", "10MB" or "1G".
+// Suffixes are binary multiples. An empty value means no limit and returns 0.
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readOutputs returns the lines written to DRONE_OUTPUT.
+func readOutputs(t *testing.T) []string {
+	t.Helper()
+	content, err := os.ReadFile(os.Getenv("DRONE_OUTPUT"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestCloseOutputsAfterTimeout(t *testing.T) {
+	t.Setenv("DRONE_OUTPUT", filepath.Join(t.TempDir(), "output"))
+	openOutputs()
+	if !closeOutputs("false") {
+		t.Fatal("the timeout did not close the outputs")
+	}
+	if err := WriteEnvToFile("TRUSTED_FILE_CONTENT", "content"); !errors.Is(err, errOutputsClosed) {
+		t.Errorf("WriteEnvToFile after the timeout = %v, want %v", err, errOutputsClosed)
+	}
+	if closeOutputs("true") {
+		t.Error("the run closed the outputs after the timeout")
+	}
+	if got := readOutputs(t); len(got) != 1 || got[0] != "TRUSTED=false" {
+		t.Errorf("outputs = %q, want only TRUSTED=false", got)
+	}
+}
+
+func TestCloseOutputsRacesWithTimeout(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 200; i++ {
+		t.Setenv("DRONE_OUTPUT", filepath.Join(dir, strconv.Itoa(i)))
+		openOutputs()
+		var wg sync.WaitGroup
+		var runClosed, timeoutClosed bool
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, key := range []string{"TRUSTED_REF", "TRUSTED_RESULTS", "TRUSTED_FILE_CONTENT"} {
+				if err := WriteEnvToFile(key, "value"); err != nil {
+					break
+				}
+			}
+			runClosed = closeOutputs("true")
+		}()
+		go func() {
+			defer wg.Done()
+			timeoutClosed = closeOutputs("false")
+		}()
+		wg.Wait()
+
+		if runClosed == timeoutClosed {
+			t.Fatalf("run closed the outputs: %v, timeout closed them: %v, want exactly one", runClosed, timeoutClosed)
+		}
+		got := readOutputs(t)
+		last := got[len(got)-1]
+		if !strings.HasPrefix(last, "TRUSTED=") || strings.Count(strings.Join(got, "\n"), "TRUSTED=") != 1 {
+			t.Fatalf("outputs = %q, want TRUSTED written once and last", got)
+		}
+		if timeoutClosed && last != "TRUSTED=false" {
+			t.Fatalf("outputs = %q after the timeout, want TRUSTED=false", got)
+		}
+		if runClosed && (last != "TRUSTED=true" || len(got) != 4) {
+			t.Fatalf("outputs = %q of a finished run, want every output and TRUSTED=true", got)
+		}
+	}
+}
+
+func TestExitOnTimeoutAfterRunFinished(t *testing.T) {
+	t.Setenv("DRONE_OUTPUT", filepath.Join(t.TempDir(), "output"))
+	openOutputs()
+	closeOutputs("true")
+	// The deadline passes right after the run wrote its result. The timer must
+	// neither change the result nor exit, which would end this test.
+	stopped := make(chan bool, 1)
+	disarm := exitOnTimeout(time.Nanosecond, func() bool {
+		closed := closeOutputs("false")
+		stopped <- closed
+		return closed
+	})
+	defer disarm()
+	if <-stopped {
+		t.Fatal("the timeout stopped a finished run")
+	}
+	if got := readOutputs(t); len(got) != 1 || got[0] != "TRUSTED=true" {
+		t.Errorf("outputs = %q, want only TRUSTED=true", got)
+	}
+}
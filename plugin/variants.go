@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// trustedSource is a file on a trusted ref that the current file may match.
+type trustedSource struct {
+	Ref  string
+	Path string
+}
+
+func (s trustedSource) String() string {
+	return s.Ref + ":" + s.Path
+}
+
+// trustedSources lists the trusted versions a file is compared against: the
+// same path on the trusted branch followed by every configured variant.
+// Variants are either a path on the trusted branch or "ref:path".
+func trustedSources(branch, filePath string, variants []string) ([]trustedSource, error) {
+	sources := []trustedSource{{Ref: branch, Path: filePath}}
+	for _, variant := range variants {
+		source := trustedSource{Ref: branch, Path: variant}
+		if ref, p, ok := strings.Cut(variant, ":"); ok {
+			source = trustedSource{Ref: ref, Path: p}
+		}
+		cleaned, err := cleanRelativePath(source.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted variant %s: %w", variant, err)
+		}
+		source.Path = cleaned
+		if source.Ref == "" {
+			return nil, fmt.Errorf("invalid trusted variant %s: ref is empty", variant)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// kubernetesTokenPath is where pods find their service account token.
+const kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultSource reads the git token from a HashiCorp Vault KV secret.
+type vaultSource struct {
+	addr      string
+	path      string
+	key       string
+	roleID    string
+	secretID  string
+	role      string
+	authMount string
+}
+
+// read logs in and returns the key of the secret at path. KV version 2
+// paths include the data segment, e.g. secret/data/ci/github.
+func (v vaultSource) read() (string, error) {
+	if v.addr == "" {
+		v.addr = os.Getenv("VAULT_ADDR")
+	}
+	if v.addr == "" {
+		return "", fmt.Errorf("vault_addr is required to read the git token from Vault")
+	}
+	v.addr = strings.TrimSuffix(v.addr, "/")
+	token, err := v.login()
+	if err != nil {
+		return "", err
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(http.MethodGet, "/v1/"+strings.Trim(v.path, "/"), token, nil, &secret); err != nil {
+		return "", fmt.Errorf("failed to read %s from Vault: %w", v.path, err)
+	}
+	// KV version 2 nests the secret in data.data.
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	key := v.key
+	if key == "" {
+		key = "token"
+	}
+	value, ok := data[key].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("the Vault secret %s has no %s", v.path, key)
+	}
+	logrus.Infof("Using the git token from the Vault secret %s", v.path)
+	return value, nil
+}
+
+// login returns a Vault token: from AppRole with vault_role_id and
+// vault_secret_id, from Kubernetes auth with vault_role and the pod's service
+// account token, or VAULT_TOKEN.
+func (v vaultSource) login() (string, error) {
+	var mount string
+	var in map[string]string
+	switch {
+	case v.roleID != "":
+		mount, in = "approle", map[string]string{"role_id": v.roleID, "secret_id": v.secretID}
+	case v.role != "":
+		jwt, err := os.ReadFile(kubernetesTokenPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the Kubernetes service account token: %w", err)
+		}
+		mount, in = "kubernetes", map[string]string{"role": v.role, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		if token := os.Getenv("VAULT_TOKEN"); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("vault_role_id, vault_role or VAULT_TOKEN is required to log in to Vault")
+	}
+	if v.authMount != "" {
+		mount = strings.Trim(v.authMount, "/")
+	}
+
+	var out struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(http.MethodPost, "/v1/auth/"+mount+"/login", "", in, &out); err != nil {
+		return "", fmt.Errorf("failed to log in to Vault with %s: %w", mount, err)
+	}
+	if out.Auth.ClientToken == "" {
+		return "", fmt.Errorf("failed to log in to Vault with %s: no client token", mount)
+	}
+	registerSecret(out.Auth.ClientToken)
+	return out.Auth.ClientToken, nil
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out.
+func (v vaultSource) do(method, path, token string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, v.addr+path, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// currentFile is the state of a planned file in the workspace.
+type currentFile struct {
+	Content string
+	// OID is the git blob object ID, only set when comparing by object ID.
+	OID string
+	// SHA256 is the streamed digest, only set when comparing by digest.
+	SHA256 string
+}
+
+// readCurrentFiles reads every planned file from the workspace. Files that no
+// longer exist are handled according to the deleted file policy.
+func readCurrentFiles(plan *verificationPlan, deletedPolicy string, limit sizeLimit) (map[string]currentFile, error) {
+	currentFiles := make(map[string]currentFile, len(plan.files))
+	for _, filePath := range plan.files {
+		currentFilePath, err := resolveInRepo(plan.repoPath, filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(currentFilePath)
+		if err == nil {
+			if err := limit.enforce(plan, filePath, info.Size()); err != nil {
+				return nil, err
+			}
+		}
+
+		var current currentFile
+		switch opts := plan.options[filePath]; {
+		case opts.readsContent():
+			var currentContentBytes []byte
+			currentContentBytes, err = os.ReadFile(currentFilePath)
+			current.Content = string(currentContentBytes)
+		case opts.Mode == compareModeSHA256:
+			current.SHA256, err = sha256File(currentFilePath)
+		default:
+			if _, err = os.Stat(currentFilePath); err == nil {
+				current.OID, err = hashObject(plan.repoPath, filePath)
+			}
+		}
+
+		if errors.Is(err, fs.ErrNotExist) {
+			reason := describeMissingFile(plan.repoPath, plan.refFor(filePath), filePath)
+			switch deletedPolicy {
+			case deletedPolicyWarn:
+				logrus.Warnf("File %s was %s, skipping verification", filePath, reason)
+				plan.unschedule(filePath)
+			case deletedPolicyMismatch:
+				logrus.Warnf("File %s was %s", filePath, reason)
+				plan.unschedule(filePath)
+				plan.fail(fileResult{Path: filePath, Reason: reason})
+			default:
+				return nil, fmt.Errorf("file %s was %s", filePath, reason)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file from current branch at %s: %w", currentFilePath, err)
+		}
+		currentFiles[filePath] = current
+	}
+	return currentFiles, nil
+}
+
+// verifyFiles compares every planned file against the trusted branch using up
+// to concurrency workers. Results and trusted contents are returned in plan order.
+func verifyFiles(plan *verificationPlan, currentFiles map[string]currentFile, concurrency int) ([]fileResult, []string, error) {
+	files := plan.files
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(files) {
+		concurrency = len(files)
+	}
+
+	results := make([]fileResult, len(files))
+	trustedContents := make([]string, len(files))
+	errs := make([]error, len(files))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], trustedContents[i], errs[i] = verifyFile(plan, files[i], currentFiles[files[i]])
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return results, trustedContents, nil
+}
+
+// verifyFile compares a single file against its trusted version, or against
+// any of its trusted variants. The trusted content is only returned when the
+// compare mode reads it.
+func verifyFile(plan *verificationPlan, filePath string, current currentFile) (fileResult, string, error) {
+	opts := plan.options[filePath]
+	ref := plan.refFor(filePath)
+	result := fileResult{Path: filePath}
+	if len(plan.trustedRefs) > 1 {
+		result.Ref = ref
+	}
+	if opts.readsContent() {
+		result.SHA256 = sha256Hex(current.Content)
+	} else if opts.Mode == compareModeSHA256 {
+		result.SHA256 = current.SHA256
+	}
+
+	sources, err := trustedSources(ref, filePath, opts.Variants)
+	if err != nil {
+		return fileResult{}, "", err
+	}
+
+	var trustedContent string
+	found := false
+	for i, source := range sources {
+		matched, content, err := compareWithSource(plan.repoPath, source, filePath, opts, current)
+		var missing *MissingTrustedFileError
+		if errors.As(err, &missing) && len(sources) > 1 {
+			logrus.Infof("Trusted variant %s does not exist, skipping", source)
+			continue
+		}
+		if err != nil {
+			return fileResult{}, "", err
+		}
+		if !found || matched {
+			trustedContent = content
+		}
+		found = true
+		if matched {
+			result.Matched = true
+			if i > 0 {
+				logrus.Infof("File %s matches trusted variant %s", filePath, source)
+			}
+			break
+		}
+	}
+	if !found {
+		return fileResult{}, "", &MissingTrustedFileError{Path: filePath, Branch: ref}
+	}
+
+	if !result.Matched && plan.history.enabled {
+		commit, content, err := matchHistory(plan, ref, filePath, opts, current)
+		if err != nil {
+			return fileResult{}, "", err
+		}
+		if commit != "" {
+			logrus.Infof("File %s matches the historical version at commit %s", filePath, commit)
+			result.Matched = true
+			result.HistoricalCommit = commit
+			trustedContent = content
+		}
+	}
+
+	// Hidden characters are reported even when the comparison passed, since
+	// normalization or containment could otherwise let them through.
+	if plan.detectHidden && opts.readsContent() && !isBinary(current.Content) {
+		if added := addedHiddenChars(trustedContent, current.Content); len(added) > 0 {
+			for _, h := range added {
+				logrus.Warnf("File %s contains hidden character %s", filePath, h)
+			}
+			result.Matched = false
+			result.Reason = hiddenCharsReason(added)
+			return result, trustedContent, nil
+		}
+	}
+
+	if result.Matched {
+		logrus.Infof("File %s matches %s", filePath, describeTrustedRef(ref))
+		// Policies add requirements on the trusted version beyond matching it.
+		for _, policy := range plan.policies {
+			reason, err := policy.check(plan.repoPath, ref, filePath)
+			if err != nil {
+				return fileResult{}, "", err
+			}
+			if reason != "" {
+				logrus.Warnf("File %s violates policy: %s", filePath, reason)
+				result.Matched = false
+				result.Reason = reason
+				break
+			}
+		}
+	} else {
+		logrus.Warnf("File %s differs from %s", filePath, describeTrustedRef(ref))
+		result.Reason = reasonContentDiffers
+		if opts.readsContent() && !isBinary(trustedContent) && !isBinary(current.Content) {
+			if tooLargeToDiff(trustedContent, current.Content) {
+				logrus.Infof("File %s is too large to diff, so no changed lines are reported", filePath)
+				return result, trustedContent, nil
+			}
+			stats := computeDiffStats(trustedContent, current.Content)
+			result.LinesAdded, result.LinesRemoved, result.Hunks = stats.Added, stats.Removed, stats.Hunks
+		}
+	}
+	return result, trustedContent, nil
+}
+
+// compareWithSource compares the current file against one trusted source.
+func compareWithSource(repoPath string, source trustedSource, filePath string, opts compareOptions, current currentFile) (bool, string, error) {
+	switch {
+	case opts.readsContent():
+		trustedContent, err := readTrustedFile(repoPath, source.Ref, source.Path)
+		if err != nil {
+			return false, "", err
+		}
+		// Compare file contents, decrypting SOPS and age files first.
+		compare := compareContent
+		switch {
+		case opts.SOPS:
+			compare = compareSOPS
+		case opts.Age:
+			compare = compareAge
+		}
+		matched, err := compare(filePath, opts, trustedContent, current.Content)
+		if err != nil {
+			return false, "", fmt.Errorf("failed to compare %s: %w", filePath, err)
+		}
+		return matched, trustedContent, nil
+	case opts.Mode == compareModeSHA256:
+		trustedSHA256, err := hashTrustedFile(repoPath, source.Ref, source.Path)
+		if err != nil {
+			return false, "", err
+		}
+		return trustedSHA256 == current.SHA256, "", nil
+	default:
+		trustedOID, err := readTrustedOID(repoPath, source.Ref, source.Path)
+		if err != nil {
+			return false, "", err
+		}
+		return trustedOID == current.OID, "", nil
+	}
+}